@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type severity int
+
+const (
+	severityInfo severity = iota
+	severityWarning
+	severityError
+	severityNone // only used as a --fail-on threshold that never trips
+)
+
+func (s severity) String() string {
+	switch s {
+	case severityInfo:
+		return "info"
+	case severityWarning:
+		return "warning"
+	case severityError:
+		return "error"
+	default:
+		return "none"
+	}
+}
+
+func parseSeverity(s string) (severity, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "info":
+		return severityInfo, nil
+	case "warning", "warn":
+		return severityWarning, nil
+	case "error":
+		return severityError, nil
+	case "none", "never":
+		return severityNone, nil
+	}
+	return severityNone, fmt.Errorf("unknown severity %q (use info, warning, error or none)", s)
+}
+
+// A problem found in one of the extracted functions
+type finding struct {
+	Severity severity
+	Function string
+	Path     string
+	Line     int // line in scripts.yaml
+	Message  string
+}
+
+// Verbs reported by Get-Verb
+var approvedVerbs = toSet(
+	// Common
+	"Add", "Clear", "Close", "Copy", "Enter", "Exit", "Find", "Format", "Get", "Hide", "Join",
+	"Lock", "Move", "New", "Open", "Optimize", "Pop", "Push", "Redo", "Remove", "Rename",
+	"Reset", "Resize", "Search", "Select", "Set", "Show", "Skip", "Split", "Step", "Switch",
+	"Undo", "Unlock", "Watch",
+	// Communications
+	"Connect", "Disconnect", "Read", "Receive", "Send", "Write",
+	// Data
+	"Backup", "Checkpoint", "Compare", "Compress", "Convert", "ConvertFrom", "ConvertTo",
+	"Dismount", "Edit", "Expand", "Export", "Group", "Import", "Initialize", "Limit", "Merge",
+	"Mount", "Out", "Publish", "Restore", "Save", "Sync", "Unpublish", "Update",
+	// Diagnostic
+	"Debug", "Measure", "Ping", "Repair", "Resolve", "Test", "Trace",
+	// Lifecycle
+	"Approve", "Assert", "Build", "Complete", "Confirm", "Deny", "Deploy", "Disable", "Enable",
+	"Install", "Invoke", "Register", "Request", "Restart", "Resume", "Start", "Stop", "Submit",
+	"Suspend", "Uninstall", "Unregister", "Wait",
+	// Security
+	"Block", "Grant", "Protect", "Revoke", "Unblock", "Unprotect",
+	// Other
+	"Use",
+)
+
+// Cmdlets shipped with Windows PowerShell / PowerShell 7 that the module relies on
+var builtinCommands = toSet(
+	"Add-ADGroupMember", "Add-Content", "Add-Member", "Add-Type", "Clear-Host", "Compare-Object",
+	"Compress-Archive", "ConvertFrom-Json", "ConvertTo-Json", "ConvertTo-SecureString",
+	"Copy-Item", "Expand-Archive", "Export-ModuleMember", "ForEach-Object", "Format-List",
+	"Format-Table", "Get-Acl", "Get-ChildItem", "Get-CimInstance", "Get-Command", "Get-Content",
+	"Get-Credential", "Get-Culture", "Get-Date", "Get-FileHash", "Get-Help", "Get-Host",
+	"Get-Item", "Get-ItemProperty", "Get-Location", "Get-Member", "Get-Module",
+	"Get-NetConnectionProfile", "Get-NetFirewallPortFilter", "Get-NetFirewallProfile",
+	"Get-NetFirewallRule", "Get-Process", "Get-PSDrive", "Get-Random", "Get-Service",
+	"Get-TimeZone", "Get-Variable", "Get-Verb", "Get-WindowsCapability", "Get-WmiObject",
+	"Group-Object", "Import-Module", "Invoke-Command", "Invoke-Expression", "Invoke-RestMethod",
+	"Invoke-WebRequest", "Join-Path", "Measure-Object", "Move-Item", "New-ADUser",
+	"New-Item", "New-ItemProperty", "New-NetFirewallRule", "New-Object", "New-PSDrive",
+	"New-TimeSpan", "New-Variable", "Out-File", "Out-Host", "Out-Null", "Out-String",
+	"Pop-Location", "Push-Location", "Read-Host", "Remove-Item", "Remove-ItemProperty",
+	"Remove-NetFirewallRule", "Remove-Variable", "Rename-Item", "Resolve-Path",
+	"Restart-Computer", "Restart-Service", "Select-Object", "Select-String", "Set-Acl",
+	"Set-Content", "Set-Date", "Set-ExecutionPolicy", "Set-Item", "Set-ItemProperty",
+	"Set-Location", "Set-Service", "Set-Variable", "Sort-Object", "Split-Path",
+	"Start-Process", "Start-Service", "Start-Sleep", "Start-Transcript", "Stop-Process",
+	"Stop-Service", "Stop-Transcript", "Tee-Object", "Test-Connection", "Test-Path",
+	"Unblock-File", "Add-WindowsCapability", "Wait-Process", "Where-Object", "Write-Debug",
+	"Write-Error", "Write-Host", "Write-Information", "Write-Output", "Write-Progress",
+	"Write-Verbose", "Write-Warning",
+)
+
+func toSet(items ...string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[strings.ToLower(item)] = true
+	}
+	return set
+}
+
+// Verb-Noun tokens in command position (code only, strings and comments masked)
+var commandRe = regexp.MustCompile(`(?m)(?:^|[\s|;({=&,!])([A-Za-z][A-Za-z0-9]*(?:-[A-Za-z0-9]+)+)`)
+
+var cmdletBindingRe = regexp.MustCompile(`(?i)\[\s*CmdletBinding\s*\(`)
+
+// Run every check over the extracted functions. extraKnown lists commands
+// provided by other modules that should not be reported as unknown.
+func analyzeFunctions(functions []psFunction, extraKnown []string) []finding {
+	var findings []finding
+
+	known := make(map[string]bool)
+	for name := range builtinCommands {
+		known[name] = true
+	}
+	for _, name := range extraKnown {
+		if name = strings.TrimSpace(name); name != "" {
+			known[strings.ToLower(name)] = true
+		}
+	}
+	for _, fn := range functions {
+		for _, name := range declaredFunctions(fn.Body) {
+			known[strings.ToLower(name)] = true
+		}
+	}
+
+	// Duplicate function names
+	firstSeen := make(map[string]psFunction)
+	for _, fn := range functions {
+		key := strings.ToLower(fn.Name)
+		if prev, dup := firstSeen[key]; dup {
+			findings = append(findings, finding{severityError, fn.Name, fn.Path, fn.Line,
+				fmt.Sprintf("duplicate function name (also defined at %s, line %d)", prev.Path, prev.Line)})
+			continue
+		}
+		firstSeen[key] = fn
+	}
+
+	for _, fn := range functions {
+		findings = append(findings, analyzeFunction(fn, known)...)
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Severity > findings[j].Severity
+	})
+	return findings
+}
+
+func analyzeFunction(fn psFunction, known map[string]bool) []finding {
+	var findings []finding
+	add := func(sev severity, bodyLine int, format string, args ...interface{}) {
+		findings = append(findings, finding{sev, fn.Name, fn.Path, fn.Line + bodyLine - 1, fmt.Sprintf(format, args...)})
+	}
+
+	if fn.Name == "" {
+		add(severityError, 1, "block starts with 'function' but no function name could be parsed")
+		return findings
+	}
+
+	// Unterminated strings and comments
+	for _, seg := range scanPowerShell(fn.Body) {
+		if !seg.Unterminated {
+			continue
+		}
+		what := "string"
+		if seg.Kind == psComment {
+			what = "block comment"
+		}
+		add(severityError, lineOf(fn.Body, seg.Start), "unterminated %s", what)
+	}
+
+	masked := maskPowerShell(fn.Body)
+
+	// Unbalanced braces, parentheses and brackets
+	for _, problem := range checkBalance(masked) {
+		add(severityError, problem.line, "%s", problem.message)
+	}
+
+	// Calls to commands that are neither in the module nor built in
+	reported := make(map[string]bool)
+	for _, m := range commandRe.FindAllStringSubmatchIndex(masked, -1) {
+		name := masked[m[2]:m[3]]
+		key := strings.ToLower(name)
+		if known[key] || reported[key] {
+			continue
+		}
+		reported[key] = true
+		add(severityWarning, lineOf(masked, m[2]), "calls '%s', which is not defined in the module and is not a known built-in command", name)
+	}
+
+	// Unapproved verbs (functions without a Verb-Noun name such as 'prompt' are exempt)
+	if verb, _, ok := strings.Cut(fn.Name, "-"); ok && !approvedVerbs[strings.ToLower(verb)] {
+		add(severityWarning, 1, "'%s' is not an approved PowerShell verb (see Get-Verb)", verb)
+	}
+
+	// Advanced function attribute
+	if !cmdletBindingRe.MatchString(masked) {
+		add(severityInfo, 1, "missing [CmdletBinding()]")
+	}
+
+	return findings
+}
+
+type balanceProblem struct {
+	line    int
+	message string
+}
+
+// Match {} () [] in masked source
+func checkBalance(masked string) []balanceProblem {
+	pairs := map[byte]byte{'}': '{', ')': '(', ']': '['}
+	type open struct {
+		char byte
+		pos  int
+	}
+	var stack []open
+	var problems []balanceProblem
+
+	for i := 0; i < len(masked); i++ {
+		c := masked[i]
+		switch c {
+		case '{', '(', '[':
+			stack = append(stack, open{c, i})
+		case '}', ')', ']':
+			if len(stack) == 0 || stack[len(stack)-1].char != pairs[c] {
+				problems = append(problems, balanceProblem{lineOf(masked, i), fmt.Sprintf("unexpected '%c'", c)})
+				return problems
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+	for _, o := range stack {
+		problems = append(problems, balanceProblem{lineOf(masked, o.pos), fmt.Sprintf("'%c' is never closed", o.char)})
+	}
+	return problems
+}
+
+// Print the findings and report whether any reached the threshold
+func printFindings(findings []finding, failOn severity) bool {
+	icons := map[severity]string{severityInfo: "ℹ️", severityWarning: "⚠️", severityError: "❌"}
+	counts := make(map[severity]int)
+	failed := false
+
+	for _, f := range findings {
+		counts[f.Severity]++
+		if f.Severity >= failOn {
+			failed = true
+		}
+		fmt.Printf("%s %-7s %s (%s, line %d): %s\n", icons[f.Severity], f.Severity, f.Function, f.Path, f.Line, f.Message)
+	}
+
+	fmt.Printf("🔎 Analysis: %d error(s), %d warning(s), %d info\n",
+		counts[severityError], counts[severityWarning], counts[severityInfo])
+	return failed
+}
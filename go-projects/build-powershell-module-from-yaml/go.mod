@@ -5,4 +5,7 @@ go 1.24.4
 require (
 	github.com/google/uuid v1.6.0
 	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
+
+require golang.org/x/crypto v0.11.0 // indirect
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A parameter declared in a function's param() block
+type psParameter struct {
+	Name        string
+	Type        string
+	Default     string
+	Mandatory   bool
+	Position    int // -1 when the parameter is named only
+	Description string
+}
+
+// Comment-based help plus the parsed param() block of one function
+type psHelp struct {
+	Synopsis    string
+	Description string
+	Examples    []string
+	Notes       string
+	Parameters  []psParameter
+}
+
+var helpKeywordRe = regexp.MustCompile(`(?im)^\s*\.([A-Z]+)[ \t]*([^\r\n]*)$`)
+
+// Parse comment-based help (the first <# #> block containing a .KEYWORD)
+// and the param() block of a function body.
+func parseHelp(body string) psHelp {
+	var help psHelp
+	paramHelp := make(map[string]string)
+
+	for _, seg := range scanPowerShell(body) {
+		if seg.Kind != psComment || !strings.HasPrefix(seg.Text, "<#") {
+			continue
+		}
+		text := strings.TrimSuffix(strings.TrimPrefix(seg.Text, "<#"), "#>")
+		matches := helpKeywordRe.FindAllStringSubmatchIndex(text, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		for i, m := range matches {
+			end := len(text)
+			if i+1 < len(matches) {
+				end = matches[i+1][0]
+			}
+			keyword := strings.ToUpper(text[m[2]:m[3]])
+			argument := strings.TrimSpace(text[m[4]:m[5]])
+			content := dedent(text[m[1]:end])
+			switch keyword {
+			case "SYNOPSIS":
+				help.Synopsis = content
+			case "DESCRIPTION":
+				help.Description = content
+			case "PARAMETER":
+				paramHelp[strings.ToLower(argument)] = content
+			case "EXAMPLE":
+				help.Examples = append(help.Examples, content)
+			case "NOTES":
+				help.Notes = content
+			}
+		}
+		break
+	}
+
+	help.Parameters = parseParamBlock(body)
+	for i, p := range help.Parameters {
+		if desc, ok := paramHelp[strings.ToLower(p.Name)]; ok {
+			help.Parameters[i].Description = desc
+		}
+	}
+	return help
+}
+
+// Trim blank lines and the common leading indentation of a help section
+func dedent(text string) string {
+	lines := strings.Split(strings.Trim(text, "\r\n"), "\n")
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if len(line) >= indent && indent > 0 {
+			line = line[indent:]
+		}
+		lines[i] = line
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+var paramKeywordRe = regexp.MustCompile(`(?i)\bparam\s*\(`)
+
+// Parse the param() block that belongs to the function itself (brace depth 1),
+// ignoring param() blocks of nested functions and script blocks.
+func parseParamBlock(body string) []psParameter {
+	masked := maskPowerShell(body)
+
+	open := -1
+	for _, m := range paramKeywordRe.FindAllStringIndex(masked, -1) {
+		if braceDepth(masked[:m[0]]) == 1 {
+			open = m[1] - 1
+			break
+		}
+	}
+	if open < 0 {
+		return nil
+	}
+	closeIdx := matchingClose(masked, open)
+	if closeIdx < 0 {
+		return nil
+	}
+
+	// Split on top-level commas
+	var chunks [][2]int
+	start := open + 1
+	depth := 0
+	for i := open + 1; i < closeIdx; i++ {
+		switch masked[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				chunks = append(chunks, [2]int{start, i})
+				start = i + 1
+			}
+		}
+	}
+	chunks = append(chunks, [2]int{start, closeIdx})
+
+	var params []psParameter
+	position := 0
+	for _, c := range chunks {
+		p, ok := parseParameter(body, masked, c[0], c[1])
+		if !ok {
+			continue
+		}
+		if p.Position == -2 { // no explicit position: PowerShell binds by declaration order
+			if p.Type == "switch" {
+				p.Position = -1
+			} else {
+				p.Position = position
+				position++
+			}
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+var (
+	parameterNameRe = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+	mandatoryRe     = regexp.MustCompile(`(?i)\bMandatory\b(\s*=\s*\$(true|false))?`)
+	positionRe      = regexp.MustCompile(`(?i)\bPosition\s*=\s*(\d+)`)
+)
+
+func parseParameter(body, masked string, start, end int) (psParameter, bool) {
+	code := masked[start:end]
+	p := psParameter{Type: "object", Position: -2}
+
+	// Leading [Attribute(...)] and [type] blocks
+	i := 0
+	for {
+		for i < len(code) && strings.ContainsRune(" \t\r\n", rune(code[i])) {
+			i++
+		}
+		if i >= len(code) || code[i] != '[' {
+			break
+		}
+		closeIdx := matchingClose(code, i)
+		if closeIdx < 0 {
+			return p, false
+		}
+		inner := strings.TrimSpace(code[i+1 : closeIdx])
+		if strings.Contains(inner, "(") {
+			if strings.HasPrefix(strings.ToLower(inner), "parameter") {
+				if m := mandatoryRe.FindStringSubmatch(inner); m != nil && !strings.EqualFold(m[2], "false") {
+					p.Mandatory = true
+				}
+				if m := positionRe.FindStringSubmatch(inner); m != nil {
+					p.Position, _ = strconv.Atoi(m[1])
+				}
+			}
+		} else {
+			p.Type = inner
+		}
+		i = closeIdx + 1
+	}
+
+	loc := parameterNameRe.FindStringSubmatchIndex(code[i:])
+	if loc == nil {
+		return p, false
+	}
+	p.Name = code[i+loc[2] : i+loc[3]]
+
+	rest := code[i+loc[1]:]
+	if eq := strings.Index(rest, "="); eq >= 0 {
+		from := start + i + loc[1] + eq + 1
+		p.Default = strings.TrimSpace(stripComments(body[from:end]))
+	}
+
+	// A comment on the same line as the parameter name serves as a short description
+	nameLine := lineOf(body, start+i+loc[0])
+	for _, seg := range scanPowerShell(body) {
+		if seg.Kind == psComment && seg.Start >= start && lineOf(body, seg.Start) == nameLine {
+			p.Description = strings.TrimSpace(strings.TrimLeft(seg.Text, "#"))
+			break
+		}
+	}
+	return p, true
+}
+
+// Remove comments from a snippet, keeping strings and code
+func stripComments(src string) string {
+	var b strings.Builder
+	for _, seg := range scanPowerShell(src) {
+		if seg.Kind != psComment {
+			b.WriteString(seg.Text)
+		}
+	}
+	return b.String()
+}
+
+// Nesting depth of { } at the end of masked source
+func braceDepth(masked string) int {
+	return strings.Count(masked, "{") - strings.Count(masked, "}")
+}
+
+// Index of the bracket closing the one at open, or -1
+func matchingClose(masked string, open int) int {
+	closers := map[byte]byte{'(': ')', '[': ']', '{': '}'}
+	var stack []byte
+	for i := open; i < len(masked); i++ {
+		c := masked[i]
+		if closer, ok := closers[c]; ok {
+			stack = append(stack, closer)
+		} else if len(stack) > 0 && c == stack[len(stack)-1] {
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Usage line such as: Add-ToPath [-PathToAdd] <string> [-Force]
+func syntaxLine(name string, params []psParameter) string {
+	parts := []string{name}
+	for _, p := range params {
+		var part string
+		if p.Type == "switch" {
+			part = "-" + p.Name
+		} else if p.Position >= 0 {
+			part = fmt.Sprintf("[-%s] <%s>", p.Name, p.Type)
+		} else {
+			part = fmt.Sprintf("-%s <%s>", p.Name, p.Type)
+		}
+		if !p.Mandatory {
+			part = "[" + part + "]"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Render the Markdown function reference
+func renderMarkdown(functions []psFunction, yamlName string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n%s\n\n", moduleName, moduleDescription)
+	fmt.Fprintf(&b, "Generated from `%s`. Do not edit by hand.\n\n", yamlName)
+
+	b.WriteString("## Functions\n\n| Function | Synopsis | Source |\n| --- | --- | --- |\n")
+	for _, fn := range functions {
+		help := parseHelp(fn.Body)
+		fmt.Fprintf(&b, "| [%s](#%s) | %s | `%s` |\n", fn.Name, markdownAnchor(fn.Name), markdownCell(help.Synopsis), fn.Path)
+	}
+	b.WriteString("\n")
+
+	for _, fn := range functions {
+		help := parseHelp(fn.Body)
+		fmt.Fprintf(&b, "## %s\n\n", fn.Name)
+		fmt.Fprintf(&b, "**Source:** `%s` (%s, line %d)\n\n", fn.Path, yamlName, fn.Line)
+
+		if help.Synopsis != "" {
+			fmt.Fprintf(&b, "### Synopsis\n\n%s\n\n", help.Synopsis)
+		}
+		if help.Description != "" {
+			fmt.Fprintf(&b, "### Description\n\n%s\n\n", help.Description)
+		}
+
+		fmt.Fprintf(&b, "### Syntax\n\n```powershell\n%s\n```\n\n", syntaxLine(fn.Name, help.Parameters))
+
+		if len(help.Parameters) > 0 {
+			b.WriteString("### Parameters\n\n| Name | Type | Mandatory | Position | Default | Description |\n| --- | --- | --- | --- | --- | --- |\n")
+			for _, p := range help.Parameters {
+				position := "named"
+				if p.Position >= 0 {
+					position = strconv.Itoa(p.Position)
+				}
+				def := ""
+				if p.Default != "" {
+					def = "`" + p.Default + "`"
+				}
+				fmt.Fprintf(&b, "| `-%s` | `%s` | %t | %s | %s | %s |\n", p.Name, p.Type, p.Mandatory, position, markdownCell(def), markdownCell(p.Description))
+			}
+			b.WriteString("\n")
+		}
+
+		for i, example := range help.Examples {
+			fmt.Fprintf(&b, "### Example %d\n\n```powershell\n%s\n```\n\n", i+1, example)
+		}
+		if help.Notes != "" {
+			fmt.Fprintf(&b, "### Notes\n\n%s\n\n", help.Notes)
+		}
+	}
+	return strings.TrimRight(b.String(), "\n") + "\n"
+}
+
+// GitHub-style heading anchor
+func markdownAnchor(heading string) string {
+	return strings.ToLower(strings.ReplaceAll(heading, " ", "-"))
+}
+
+func markdownCell(text string) string {
+	text = strings.ReplaceAll(text, "|", `\|`)
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Point a function at the MAML help by putting a .ExternalHelp comment at
+// the top of its body, ahead of any comment-based help it has
+func withExternalHelp(body string) string {
+	brace := strings.Index(body, "{")
+	if brace < 0 {
+		return body
+	}
+	return body[:brace+1] + "\n    # .ExternalHelp " + moduleName + ".psm1-help.xml" + body[brace+1:]
+}
+
+// Render external help in MAML. PowerShell only uses it for script module
+// functions that carry a "# .ExternalHelp MyModule.psm1-help.xml" comment,
+// which withExternalHelp adds.
+func renderMaml(functions []psFunction) string {
+	var b strings.Builder
+	b.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+	b.WriteString("<helpItems schema=\"maml\" xmlns=\"http://msh\">\n")
+
+	for _, fn := range functions {
+		help := parseHelp(fn.Body)
+		verb, noun, _ := strings.Cut(fn.Name, "-")
+		synopsis := help.Synopsis
+		if synopsis == "" {
+			synopsis = fmt.Sprintf("Defined in scripts.yaml at %s.", fn.Path)
+		}
+
+		b.WriteString("  <command:command xmlns:maml=\"http://schemas.microsoft.com/maml/2004/10\" xmlns:command=\"http://schemas.microsoft.com/maml/dev/command/2004/10\" xmlns:dev=\"http://schemas.microsoft.com/maml/dev/2004/10\">\n")
+		b.WriteString("    <command:details>\n")
+		fmt.Fprintf(&b, "      <command:name>%s</command:name>\n", xmlEscape(fn.Name))
+		fmt.Fprintf(&b, "      <command:verb>%s</command:verb>\n", xmlEscape(verb))
+		fmt.Fprintf(&b, "      <command:noun>%s</command:noun>\n", xmlEscape(noun))
+		b.WriteString("      <maml:description>\n" + mamlParas("        ", synopsis) + "      </maml:description>\n")
+		b.WriteString("    </command:details>\n")
+		b.WriteString("    <maml:description>\n" + mamlParas("      ", help.Description) + "    </maml:description>\n")
+
+		b.WriteString("    <command:syntax>\n      <command:syntaxItem>\n")
+		fmt.Fprintf(&b, "        <maml:name>%s</maml:name>\n", xmlEscape(fn.Name))
+		for _, p := range help.Parameters {
+			b.WriteString(mamlParameter("        ", p, false))
+		}
+		b.WriteString("      </command:syntaxItem>\n    </command:syntax>\n")
+
+		b.WriteString("    <command:parameters>\n")
+		for _, p := range help.Parameters {
+			b.WriteString(mamlParameter("      ", p, true))
+		}
+		b.WriteString("    </command:parameters>\n")
+
+		if len(help.Examples) > 0 {
+			b.WriteString("    <command:examples>\n")
+			for i, example := range help.Examples {
+				b.WriteString("      <command:example>\n")
+				fmt.Fprintf(&b, "        <maml:title>-------------------------- Example %d --------------------------</maml:title>\n", i+1)
+				fmt.Fprintf(&b, "        <dev:code>%s</dev:code>\n", xmlEscape(example))
+				b.WriteString("        <dev:remarks />\n")
+				b.WriteString("      </command:example>\n")
+			}
+			b.WriteString("    </command:examples>\n")
+		}
+		b.WriteString("  </command:command>\n")
+	}
+	b.WriteString("</helpItems>\n")
+	return b.String()
+}
+
+func mamlParameter(indent string, p psParameter, full bool) string {
+	var b strings.Builder
+	position := "named"
+	if p.Position >= 0 {
+		position = strconv.Itoa(p.Position + 1) // MAML positions are 1-based
+	}
+	valueType := p.Type
+	if valueType == "switch" {
+		valueType = "SwitchParameter"
+	}
+	fmt.Fprintf(&b, "%s<command:parameter required=\"%t\" variableLength=\"true\" globbing=\"false\" pipelineInput=\"False\" position=\"%s\">\n", indent, p.Mandatory, position)
+	fmt.Fprintf(&b, "%s  <maml:name>%s</maml:name>\n", indent, xmlEscape(p.Name))
+	if full {
+		fmt.Fprintf(&b, "%s  <maml:description>\n%s  </maml:description>\n", indent, mamlParas(indent+"    ", p.Description))
+	}
+	if p.Type != "switch" || full {
+		fmt.Fprintf(&b, "%s  <command:parameterValue required=\"%t\" variableLength=\"false\">%s</command:parameterValue>\n", indent, p.Type != "switch", xmlEscape(valueType))
+	}
+	if full {
+		fmt.Fprintf(&b, "%s  <dev:type>\n%s    <maml:name>%s</maml:name>\n%s  </dev:type>\n", indent, indent, xmlEscape(valueType), indent)
+		if p.Default != "" {
+			fmt.Fprintf(&b, "%s  <dev:defaultValue>%s</dev:defaultValue>\n", indent, xmlEscape(p.Default))
+		} else {
+			fmt.Fprintf(&b, "%s  <dev:defaultValue>None</dev:defaultValue>\n", indent)
+		}
+	}
+	fmt.Fprintf(&b, "%s</command:parameter>\n", indent)
+	return b.String()
+}
+
+func mamlParas(indent, text string) string {
+	if text == "" {
+		return indent + "<maml:para />\n"
+	}
+	return fmt.Sprintf("%s<maml:para>%s</maml:para>\n", indent, xmlEscape(text))
+}
+
+var xmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+func xmlEscape(text string) string {
+	return xmlReplacer.Replace(text)
+}
+
+// The Markdown reference and, if requested, MAML help under en-US
+func renderHelpFiles(functions []psFunction, yamlPath, docsPath, outputDir string, maml bool) []generatedFile {
+	files := []generatedFile{{docsPath, renderMarkdown(functions, filepath.Base(yamlPath))}}
+	if maml {
+		mamlPath := filepath.Join(outputDir, "en-US", moduleName+".psm1-help.xml")
+		files = append(files, generatedFile{mamlPath, renderMaml(functions)})
+	}
+	return files
+}
+
+// Write help files whose content changed
+func writeHelpFiles(files []generatedFile) error {
+	for _, f := range files {
+		written, err := writeIfChanged(f)
+		if err != nil {
+			return fmt.Errorf("❌ Failed to write help: %w", err)
+		}
+		if written {
+			fmt.Println("📘 Help written to:", f.Path)
+		} else {
+			fmt.Println("⏭️ Help unchanged:", f.Path)
+		}
+	}
+	return nil
+}
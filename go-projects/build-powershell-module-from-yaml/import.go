@@ -0,0 +1,350 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A top-level function found in a .ps1/.psm1 file
+type scriptFunction struct {
+	Name   string
+	Body   string
+	Source string
+}
+
+// A text replacement in scripts.yaml, expressed in 0-based line numbers.
+// Lines [From, To) are replaced by Lines; From == To inserts.
+type yamlEdit struct {
+	From, To int
+	Lines    []string
+}
+
+// import: harvest functions from .ps1/.psm1 files back into scripts.yaml.
+// Known functions are updated in place, new ones are added under --section.
+// The YAML node tree is only used to locate blocks; everything else in the
+// file (comments, ordering, quoting) is left byte for byte as it was.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	yamlPath := fs.String("yaml", filepath.Join(repoDir(), "scripts.yaml"), "Path to scripts.yaml")
+	section := fs.String("section", "general.imported", "Dotted YAML path new functions are placed under")
+	dryRun := fs.Bool("dry-run", false, "Report what would change without writing scripts.yaml")
+	fs.Usage = func() {
+		fmt.Println("Usage: build-powershell-module-from-yaml import [flags] <file.ps1|file.psm1>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var imported []scriptFunction
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic(fmt.Errorf("❌ Failed to read %s: %w", path, err))
+		}
+		found := parseScriptFunctions(string(data), path)
+		fmt.Printf("📄 %s: %d function(s)\n", path, len(found))
+		imported = append(imported, found...)
+	}
+
+	data, root, err := readYamlNode(*yamlPath)
+	if err != nil {
+		panic(err)
+	}
+	var existing []psFunction
+	if len(root.Content) > 0 {
+		extractFunctions(root.Content[0], nil, &existing)
+	}
+	byName := make(map[string]psFunction)
+	for _, fn := range existing {
+		if _, dup := byName[strings.ToLower(fn.Name)]; !dup {
+			byName[strings.ToLower(fn.Name)] = fn
+		}
+	}
+
+	newline := "\n"
+	if strings.Contains(string(data), "\r\n") {
+		newline = "\r\n"
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+
+	var edits []yamlEdit
+	var used, added []scriptFunction
+	seen := make(map[string]bool)
+	updated, unchanged := 0, 0
+
+	for _, fn := range imported {
+		key := strings.ToLower(fn.Name)
+		if seen[key] {
+			fmt.Printf("⚠️ %s appears more than once in the input; ignoring the copy in %s\n", fn.Name, fn.Source)
+			continue
+		}
+		seen[key] = true
+		used = append(used, fn)
+
+		target, ok := byName[key]
+		if !ok {
+			added = append(added, fn)
+			continue
+		}
+		if normalizeBody(target.Body) == normalizeBody(fn.Body) {
+			unchanged++
+			continue
+		}
+		edit, err := replaceBlockEdit(lines, target, fn.Body)
+		if err != nil {
+			fmt.Printf("⚠️ Skipping %s: %v\n", fn.Name, err)
+			continue
+		}
+		edits = append(edits, edit)
+		updated++
+		fmt.Printf("✏️  %s → %s\n", fn.Name, target.Path)
+	}
+
+	if len(added) > 0 {
+		edit, err := insertFunctionsEdit(lines, root, *section, added)
+		if err != nil {
+			panic(fmt.Errorf("❌ Cannot place new functions under '%s': %w", *section, err))
+		}
+		edits = append(edits, edit)
+		for _, fn := range added {
+			fmt.Printf("➕ %s → %s.%s\n", fn.Name, *section, fn.Name)
+		}
+	}
+
+	fmt.Printf("📊 %d updated, %d added, %d unchanged\n", updated, len(added), unchanged)
+	if len(edits) == 0 {
+		fmt.Println("✅ scripts.yaml already matches the imported functions.")
+		return
+	}
+
+	result := strings.Join(applyEdits(lines, edits), newline)
+	if err := verifyImport(result, used); err != nil {
+		panic(fmt.Errorf("❌ Import produced YAML that does not read back correctly, nothing written: %w", err))
+	}
+
+	if *dryRun {
+		fmt.Println("ℹ️ Dry run: scripts.yaml not written.")
+		return
+	}
+
+	backupPath := *yamlPath + ".bak"
+	if err := os.WriteFile(backupPath, data, 0644); err != nil {
+		panic(fmt.Errorf("❌ Failed to back up YAML: %w", err))
+	}
+	fmt.Printf("🔁 Existing file backed up: %s → %s\n", *yamlPath, backupPath)
+
+	if err := os.WriteFile(*yamlPath, []byte(result), 0644); err != nil {
+		panic(fmt.Errorf("❌ Failed to write YAML: %w", err))
+	}
+	fmt.Println("✅ scripts.yaml updated:", *yamlPath)
+}
+
+var scriptFunctionRe = regexp.MustCompile(`(?im)^[ \t]*function\s+([A-Za-z0-9_:.-]+)`)
+
+// Find top-level function definitions (not nested ones) in a script file
+func parseScriptFunctions(src, source string) []scriptFunction {
+	src = strings.TrimPrefix(src, "\ufeff")
+	src = strings.ReplaceAll(src, "\r\n", "\n")
+	masked := maskPowerShell(src)
+
+	var functions []scriptFunction
+	for _, m := range scriptFunctionRe.FindAllStringSubmatchIndex(masked, -1) {
+		if braceDepth(masked[:m[0]]) != 0 {
+			continue
+		}
+		open := strings.IndexByte(masked[m[1]:], '{')
+		if open < 0 {
+			continue
+		}
+		closeIdx := matchingClose(masked, m[1]+open)
+		if closeIdx < 0 {
+			fmt.Printf("⚠️ %s: function %s is never closed\n", source, masked[m[2]:m[3]])
+			continue
+		}
+		functions = append(functions, scriptFunction{
+			Name:   masked[m[2]:m[3]],
+			Body:   dedent(src[m[0] : closeIdx+1]),
+			Source: source,
+		})
+	}
+	return functions
+}
+
+// Compare bodies ignoring trailing whitespace and line endings
+func normalizeBody(body string) string {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimSpace(body), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.Join(lines, "\n")
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// Last line (0-based) of the block that starts with the key on line start,
+// i.e. the last non-blank line indented deeper than the key
+func blockEnd(lines []string, start, keyIndent int) int {
+	end := start
+	for i := start + 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			continue
+		}
+		if indentOf(lines[i]) <= keyIndent {
+			break
+		}
+		end = i
+	}
+	return end
+}
+
+// Render a function as block scalar content lines
+func blockLines(body string, indent int) []string {
+	prefix := strings.Repeat(" ", indent)
+	var out []string
+	for _, line := range strings.Split(normalizeBody(body), "\n") {
+		if line == "" {
+			out = append(out, "")
+		} else {
+			out = append(out, prefix+line)
+		}
+	}
+	return out
+}
+
+// Replace the content of an existing literal block scalar
+func replaceBlockEdit(lines []string, target psFunction, body string) (yamlEdit, error) {
+	if target.Node.Style != yaml.LiteralStyle {
+		return yamlEdit{}, fmt.Errorf("%s is not a literal block scalar (|)", target.Path)
+	}
+	header := target.Node.Line - 1
+	keyIndent := target.Key.Column - 1
+	end := blockEnd(lines, header, keyIndent)
+
+	indent := keyIndent + 2
+	for i := header + 1; i <= end; i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			indent = indentOf(lines[i])
+			break
+		}
+	}
+	return yamlEdit{From: header + 1, To: end + 1, Lines: blockLines(body, indent)}, nil
+}
+
+// Append new functions under a dotted section path, creating missing keys
+func insertFunctionsEdit(lines []string, root *yaml.Node, section string, functions []scriptFunction) (yamlEdit, error) {
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return yamlEdit{}, fmt.Errorf("document is not a mapping")
+	}
+
+	var parts []string
+	for _, part := range strings.Split(section, ".") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	// Walk down as far as the section already exists
+	mapping := root.Content[0]
+	var key *yaml.Node
+	depth := 0
+	for ; depth < len(parts); depth++ {
+		var next, nextKey *yaml.Node
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if strings.EqualFold(mapping.Content[i].Value, parts[depth]) {
+				nextKey, next = mapping.Content[i], mapping.Content[i+1]
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.Kind != yaml.MappingNode {
+			return yamlEdit{}, fmt.Errorf("'%s' is not a mapping", strings.Join(parts[:depth+1], "."))
+		}
+		mapping, key = next, nextKey
+	}
+
+	// Where to insert and at which indentation
+	insertAt := len(lines)
+	for insertAt > 0 && strings.TrimSpace(lines[insertAt-1]) == "" {
+		insertAt--
+	}
+	childIndent := 0
+	if key != nil {
+		insertAt = blockEnd(lines, key.Line-1, key.Column-1) + 1
+		childIndent = key.Column - 1 + 2
+	}
+	if len(mapping.Content) > 0 {
+		childIndent = mapping.Content[0].Column - 1
+	}
+
+	var out []string
+	for _, part := range parts[depth:] {
+		out = append(out, strings.Repeat(" ", childIndent)+yamlKey(part)+":")
+		childIndent += 2
+	}
+	for _, fn := range functions {
+		out = append(out, strings.Repeat(" ", childIndent)+yamlKey(fn.Name)+": |")
+		out = append(out, blockLines(fn.Body, childIndent+2)...)
+	}
+	return yamlEdit{From: insertAt, To: insertAt, Lines: out}, nil
+}
+
+// Quote a mapping key only when it would not survive as a plain scalar
+func yamlKey(key string) string {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(key+": x"), &node); err == nil &&
+		len(node.Content) > 0 && len(node.Content[0].Content) == 2 &&
+		node.Content[0].Content[0].Value == key {
+		return key
+	}
+	return fmt.Sprintf("%q", key)
+}
+
+// Apply edits bottom-up so earlier line numbers stay valid
+func applyEdits(lines []string, edits []yamlEdit) []string {
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].From > edits[j].From })
+	result := append([]string{}, lines...)
+	for _, e := range edits {
+		tail := append(append([]string{}, e.Lines...), result[e.To:]...)
+		result = append(result[:e.From], tail...)
+	}
+	return result
+}
+
+// Re-parse the new YAML and make sure every imported function reads back intact
+func verifyImport(result string, imported []scriptFunction) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(result), &root); err != nil {
+		return err
+	}
+	var functions []psFunction
+	if len(root.Content) > 0 {
+		extractFunctions(root.Content[0], nil, &functions)
+	}
+	bodies := make(map[string]string)
+	for _, fn := range functions {
+		if _, dup := bodies[strings.ToLower(fn.Name)]; !dup {
+			bodies[strings.ToLower(fn.Name)] = normalizeBody(fn.Body)
+		}
+	}
+	for _, fn := range imported {
+		if bodies[strings.ToLower(fn.Name)] != normalizeBody(fn.Body) {
+			return fmt.Errorf("function %s does not match after import", fn.Name)
+		}
+	}
+	return nil
+}
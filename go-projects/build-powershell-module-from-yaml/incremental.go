@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Header line in the .psd1 recording the hash the module was built from
+const contentHashPrefix = "# Content hash: sha256:"
+
+var utf8Bom = []byte{0xEF, 0xBB, 0xBF}
+
+// A generated file other than the .psm1/.psd1 pair
+type generatedFile struct {
+	Path    string
+	Content string
+}
+
+// Hash of everything that ends up in the generated module: its metadata
+// and the extracted functions. The build date and GUID are not included.
+func contentHash(psm1Content string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", moduleName, moduleDescription, psm1Content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// What a previous build left in the .psd1
+type manifestState struct {
+	Hash string
+	GUID string
+}
+
+var (
+	manifestHashRe = regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(contentHashPrefix) + `([0-9a-f]{64})`)
+	manifestGUIDRe = regexp.MustCompile(`(?m)^GUID\s*=\s*'([^']+)'`)
+)
+
+// Read the content hash and GUID of an existing manifest (empty if missing)
+func readManifestState(psd1Path string) manifestState {
+	var state manifestState
+	data, err := os.ReadFile(psd1Path)
+	if err != nil {
+		return state
+	}
+	if m := manifestHashRe.FindSubmatch(data); m != nil {
+		state.Hash = string(m[1])
+	}
+	if m := manifestGUIDRe.FindSubmatch(data); m != nil {
+		state.GUID = string(m[1])
+	}
+	return state
+}
+
+// Whether the .psm1 on disk still holds exactly the content we would write,
+// so hand edits to the generated file are detected too. A signature block
+// appended by --pfx is not part of the content.
+func psm1UpToDate(path, content string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(bytes.TrimPrefix(stripSignature(data), utf8Bom), []byte(content))
+}
+
+// Write f only if its content differs from what is on disk
+func writeIfChanged(f generatedFile) (bool, error) {
+	if existing, err := os.ReadFile(f.Path); err == nil && string(existing) == f.Content {
+		return false, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(f.Path, []byte(f.Content), 0644)
+}
+
+// Paths of help files that are missing or out of date
+func staleHelpFiles(files []generatedFile) []string {
+	var stale []string
+	for _, f := range files {
+		if existing, err := os.ReadFile(f.Path); err != nil || string(existing) != f.Content {
+			stale = append(stale, f.Path)
+		}
+	}
+	return stale
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -14,24 +15,54 @@ import (
 const moduleName = "MyModule"
 const moduleDescription = "PowerShell utilities for configuring Windows systems, managing environments, customizing time and date settings, and automating administrative tasks."
 
+// A PowerShell function found in scripts.yaml
+type psFunction struct {
+	Name string
+	Path string // YAML key path, e.g. "configuration.explorer.dark mode.on"
+	Line int    // line in scripts.yaml where the function starts
+	Body string
+	Key  *yaml.Node // mapping key the function is stored under
+	Node *yaml.Node // block scalar holding the function
+}
+
 // Recursively extract all PowerShell functions
-func extractFunctions(node *yaml.Node, functions *[]string) {
+func extractFunctions(node *yaml.Node, path []string, functions *[]psFunction) {
 	if node.Kind == yaml.MappingNode {
 		for i := 0; i < len(node.Content); i += 2 {
+			keyNode := node.Content[i]
 			valNode := node.Content[i+1]
+			valPath := append(append([]string{}, path...), keyNode.Value)
 			if valNode.Kind == yaml.ScalarNode && strings.HasPrefix(strings.TrimSpace(valNode.Value), "function") {
-				*functions = append(*functions, strings.TrimSpace(valNode.Value))
+				fn := newPsFunction(valNode, valPath)
+				fn.Key = keyNode
+				*functions = append(*functions, fn)
 			} else {
-				extractFunctions(valNode, functions)
+				extractFunctions(valNode, valPath, functions)
 			}
 		}
 	} else if node.Kind == yaml.SequenceNode {
-		for _, item := range node.Content {
-			extractFunctions(item, functions)
+		for i, item := range node.Content {
+			extractFunctions(item, append(append([]string{}, path...), fmt.Sprint(i)), functions)
 		}
 	}
 }
 
+func newPsFunction(node *yaml.Node, path []string) psFunction {
+	body := strings.TrimSpace(node.Value)
+	line := node.Line
+	if node.Style == yaml.LiteralStyle || node.Style == yaml.FoldedStyle {
+		line++ // content starts on the line after the | indicator
+	}
+	line += strings.Count(node.Value[:strings.Index(node.Value, body)], "\n")
+	return psFunction{
+		Name: functionName(body),
+		Path: strings.Join(path, "."),
+		Line: line,
+		Body: body,
+		Node: node,
+	}
+}
+
 // Backup to a fixed .bak file (overwrite if it already exists), then write the new file with BOM
 func overwriteWithSingleBackup(path string, content string) error {
 	if _, err := os.Stat(path); err == nil {
@@ -44,19 +75,24 @@ func overwriteWithSingleBackup(path string, content string) error {
 	}
 
 	// Prepend UTF-8 BOM
-	utf8Bom := []byte{0xEF, 0xBB, 0xBF}
-	data := append(utf8Bom, []byte(content)...)
+	data := append(append([]byte{}, utf8Bom...), []byte(content)...)
 	return os.WriteFile(path, data, 0644)
 }
 
-// Write collected functions into a .psm1 file
-func writePsm1(functions []string, path string) error {
-	content := strings.Join(functions, "\n\n")
-	return overwriteWithSingleBackup(path, content)
+// Join collected functions into the content of the .psm1 file
+func renderPsm1(functions []psFunction, externalHelp bool) string {
+	bodies := make([]string, len(functions))
+	for i, fn := range functions {
+		bodies[i] = fn.Body
+		if externalHelp {
+			bodies[i] = withExternalHelp(fn.Body)
+		}
+	}
+	return strings.Join(bodies, "\n\n")
 }
 
 // Generate PowerShell module manifest (.psd1)
-func writePsd1(path string, moduleName string, description string, guid string) error {
+func writePsd1(path string, moduleName string, description string, guid string, hash string) error {
 	content := fmt.Sprintf(`# 
 # Module manifest for module '%s'
 #
@@ -64,6 +100,8 @@ func writePsd1(path string, moduleName string, description string, guid string)
 #
 # Generated on: %s
 #
+`+contentHashPrefix+`%s
+#
 
 @{
 
@@ -194,35 +232,84 @@ PrivateData = @{
 # DefaultCommandPrefix = ''
 
 }
-`, moduleName, time.Now().Format("1/2/2006"), moduleName, guid, description)
+`, moduleName, time.Now().Format("1/2/2006"), hash, moduleName, guid, description)
 
 	return overwriteWithSingleBackup(path, content)
 }
 
-func main() {
+// Default location of the configuration repository
+func repoDir() string {
 	homeDir, _ := os.UserHomeDir()
-	yamlPath := filepath.Join(homeDir, "Desktop", "GitHub-repositories", "configuration", "scripts.yaml")
-	outputDir := filepath.Join(homeDir, "Desktop", "GitHub-repositories", "configuration", "output")
-	psm1Path := filepath.Join(outputDir, moduleName+".psm1")
-	psd1Path := filepath.Join(outputDir, moduleName+".psd1")
+	return filepath.Join(homeDir, "Desktop", "GitHub-repositories", "configuration")
+}
 
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		panic(fmt.Errorf("❌ Failed to create output directory: %w", err))
+// Read and parse a YAML file, keeping the node tree
+func readYamlNode(path string) ([]byte, *yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("❌ Failed to read YAML: %w", err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("❌ Failed to parse YAML: %w", err)
+	}
+	return data, &root, nil
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImport(os.Args[2:])
+			return
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		}
 	}
 
-	yamlBytes, err := os.ReadFile(yamlPath)
+	repoDir := repoDir()
+
+	yamlFlag := flag.String("yaml", filepath.Join(repoDir, "scripts.yaml"), "Path to scripts.yaml")
+	outputFlag := flag.String("output", filepath.Join(repoDir, "output"), "Directory to write the module files to")
+	failOnFlag := flag.String("fail-on", "error", "Abort the build if the analysis reports anything at or above this severity (info, warning, error, none)")
+	knownFlag := flag.String("known", "", "Comma-separated commands from other modules that functions may call")
+	docsFlag := flag.String("docs", "", "Path for the Markdown function reference (default <output>/"+moduleName+".md)")
+	mamlFlag := flag.Bool("maml", false, "Also write MAML external help to <output>/en-US/"+moduleName+".psm1-help.xml")
+	checkFlag := flag.Bool("check", false, "Write nothing; exit non-zero if the output directory is stale relative to the YAML")
+	pfxFlag := flag.String("pfx", "", "Authenticode-sign the .psm1 and .psd1 with the certificate in this PFX file")
+	pfxPasswordFlag := flag.String("pfx-password", "", "Password for --pfx (default $MODULE_SIGNING_PASSWORD)")
+	flag.Parse()
+
+	// Read the password from the environment only now, so usage never prints it
+	pfxPassword, passwordGiven := *pfxPasswordFlag, false
+	flag.Visit(func(f *flag.Flag) {
+		passwordGiven = passwordGiven || f.Name == "pfx-password"
+	})
+	if !passwordGiven {
+		pfxPassword = os.Getenv("MODULE_SIGNING_PASSWORD")
+	}
+
+	failOn, err := parseSeverity(*failOnFlag)
 	if err != nil {
-		panic(fmt.Errorf("❌ Failed to read YAML: %w", err))
+		fmt.Println("❌", err)
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	var root yaml.Node
-	if err := yaml.Unmarshal(yamlBytes, &root); err != nil {
-		panic(fmt.Errorf("❌ Failed to parse YAML: %w", err))
+	yamlPath := *yamlFlag
+	outputDir := *outputFlag
+	psm1Path := filepath.Join(outputDir, moduleName+".psm1")
+	psd1Path := filepath.Join(outputDir, moduleName+".psd1")
+
+	_, root, err := readYamlNode(yamlPath)
+	if err != nil {
+		panic(err)
 	}
 
-	var functions []string
+	var functions []psFunction
 	if len(root.Content) > 0 {
-		extractFunctions(root.Content[0], &functions)
+		extractFunctions(root.Content[0], nil, &functions)
 	}
 
 	if len(functions) == 0 {
@@ -231,15 +318,81 @@ func main() {
 		fmt.Printf("✅ %d PowerShell functions extracted.\n", len(functions))
 	}
 
-	if err := writePsm1(functions, psm1Path); err != nil {
-		panic(fmt.Errorf("❌ Failed to write .psm1: %w", err))
+	findings := analyzeFunctions(functions, strings.Split(*knownFlag, ","))
+	if printFindings(findings, failOn) {
+		fmt.Printf("❌ Analysis found problems at or above '%s'. Module not written.\n", failOn)
+		os.Exit(1)
+	}
+
+	docsPath := *docsFlag
+	if docsPath == "" {
+		docsPath = filepath.Join(outputDir, moduleName+".md")
+	}
+
+	psm1Content := renderPsm1(functions, *mamlFlag)
+	hash := contentHash(psm1Content)
+	manifest := readManifestState(psd1Path)
+	moduleCurrent := manifest.Hash == hash && psm1UpToDate(psm1Path, psm1Content)
+	helpFiles := renderHelpFiles(functions, yamlPath, docsPath, outputDir, *mamlFlag)
+
+	if *checkFlag {
+		stale := staleHelpFiles(helpFiles)
+		if !moduleCurrent {
+			stale = append([]string{psm1Path, psd1Path}, stale...)
+		}
+		if len(stale) > 0 {
+			fmt.Printf("❌ Output is stale relative to %s (content hash %s):\n", yamlPath, hash[:12])
+			for _, path := range stale {
+				fmt.Println("   •", path)
+			}
+			fmt.Println("ℹ️ Run build-powershell-module-from-yaml to regenerate.")
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Output is up to date (content hash %s).\n", hash[:12])
+		return
+	}
+
+	// Load the certificate before writing anything so a bad PFX or password fails early
+	var signer *signingIdentity
+	if *pfxFlag != "" {
+		if signer, err = loadPFX(*pfxFlag, pfxPassword); err != nil {
+			panic(fmt.Errorf("❌ Failed to load signing certificate: %w", err))
+		}
+		fmt.Printf("🔑 Signing with %s (%s)\n", signer.Cert.Subject.CommonName, thumbprint(signer.Cert))
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		panic(fmt.Errorf("❌ Failed to create output directory: %w", err))
 	}
 
-	guid := uuid.New().String()
+	if moduleCurrent {
+		fmt.Printf("⏭️ Module unchanged (content hash %s). Skipping .psm1 and .psd1.\n", hash[:12])
+	} else {
+		if err := overwriteWithSingleBackup(psm1Path, psm1Content); err != nil {
+			panic(fmt.Errorf("❌ Failed to write .psm1: %w", err))
+		}
+
+		// Keep the module identity stable across rebuilds
+		guid := manifest.GUID
+		if guid == "" {
+			guid = uuid.New().String()
+		}
 
-	if err := writePsd1(psd1Path, moduleName, moduleDescription, guid); err != nil {
-		panic(fmt.Errorf("❌ Failed to write .psd1: %w", err))
+		if err := writePsd1(psd1Path, moduleName, moduleDescription, guid, hash); err != nil {
+			panic(fmt.Errorf("❌ Failed to write .psd1: %w", err))
+		}
+		fmt.Println("✅ Module files written to:", outputDir)
 	}
 
-	fmt.Println("✅ Module files written to:", outputDir)
+	if signer != nil {
+		for _, path := range []string{psm1Path, psd1Path} {
+			if err := signFile(path, signer); err != nil {
+				panic(fmt.Errorf("❌ Failed to sign module: %w", err))
+			}
+		}
+	}
+
+	if err := writeHelpFiles(helpFiles); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Kinds of text the PowerShell scanner distinguishes
+type psSegmentKind int
+
+const (
+	psCode psSegmentKind = iota
+	psString
+	psComment
+)
+
+// A run of PowerShell source that is all code, all string or all comment
+type psSegment struct {
+	Kind         psSegmentKind
+	Text         string
+	Start        int  // byte offset into the scanned source
+	Unterminated bool // string or comment ran off the end of the source
+}
+
+// Split PowerShell source into code, string and comment segments.
+// Handles single/double quoted strings, here-strings, $(...) inside
+// double quoted strings, line comments and <# block comments #>.
+func scanPowerShell(src string) []psSegment {
+	var segments []psSegment
+	codeStart := 0
+
+	flushCode := func(end int) {
+		if end > codeStart {
+			segments = append(segments, psSegment{Kind: psCode, Text: src[codeStart:end], Start: codeStart})
+		}
+	}
+
+	i := 0
+	for i < len(src) {
+		var kind psSegmentKind
+		var end int
+		var ok bool
+
+		switch {
+		case strings.HasPrefix(src[i:], "<#"):
+			kind = psComment
+			end, ok = scanBlockComment(src, i)
+		case src[i] == '#' && (i == 0 || isCommentBoundary(src[i-1])):
+			kind = psComment
+			end, ok = scanLineComment(src, i)
+		case isHereStringStart(src, i):
+			kind = psString
+			end, ok = scanHereString(src, i)
+		case src[i] == '"':
+			kind = psString
+			end, ok = scanDoubleQuoted(src, i)
+		case src[i] == '\'':
+			kind = psString
+			end, ok = scanSingleQuoted(src, i)
+		default:
+			i++
+			continue
+		}
+
+		flushCode(i)
+		segments = append(segments, psSegment{Kind: kind, Text: src[i:end], Start: i, Unterminated: !ok})
+		i = end
+		codeStart = end
+	}
+	flushCode(len(src))
+	return segments
+}
+
+// '#' only starts a comment at the beginning of a token
+func isCommentBoundary(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || strings.IndexByte(";(){}|", c) >= 0
+}
+
+func scanBlockComment(src string, i int) (int, bool) {
+	if idx := strings.Index(src[i+2:], "#>"); idx >= 0 {
+		return i + 2 + idx + 2, true
+	}
+	return len(src), false
+}
+
+func scanLineComment(src string, i int) (int, bool) {
+	if idx := strings.IndexByte(src[i:], '\n'); idx >= 0 {
+		return i + idx, true
+	}
+	return len(src), true
+}
+
+// @" or @' followed only by whitespace up to the end of the line
+func isHereStringStart(src string, i int) bool {
+	if src[i] != '@' || i+1 >= len(src) || (src[i+1] != '"' && src[i+1] != '\'') {
+		return false
+	}
+	rest := src[i+2:]
+	if nl := strings.IndexByte(rest, '\n'); nl >= 0 {
+		rest = rest[:nl]
+	}
+	return strings.TrimSpace(rest) == ""
+}
+
+// Here-strings end with "@ or '@ at the very start of a line
+func scanHereString(src string, i int) (int, bool) {
+	terminator := "\n" + string(src[i+1]) + "@"
+	if idx := strings.Index(src[i+2:], terminator); idx >= 0 {
+		return i + 2 + idx + len(terminator), true
+	}
+	return len(src), false
+}
+
+func scanSingleQuoted(src string, i int) (int, bool) {
+	for j := i + 1; j < len(src); j++ {
+		if src[j] == '\'' {
+			if j+1 < len(src) && src[j+1] == '\'' {
+				j++ // '' is an escaped quote
+				continue
+			}
+			return j + 1, true
+		}
+	}
+	return len(src), false
+}
+
+func scanDoubleQuoted(src string, i int) (int, bool) {
+	for j := i + 1; j < len(src); j++ {
+		switch {
+		case src[j] == '`':
+			j++ // backtick escapes the next character
+		case src[j] == '"':
+			if j+1 < len(src) && src[j+1] == '"' {
+				j++ // "" is an escaped quote
+				continue
+			}
+			return j + 1, true
+		case strings.HasPrefix(src[j:], "$("):
+			end, ok := scanSubexpression(src, j+2)
+			if !ok {
+				return len(src), false
+			}
+			j = end - 1
+		}
+	}
+	return len(src), false
+}
+
+// Scan code inside $( ... ) up to and including the matching ')'
+func scanSubexpression(src string, i int) (int, bool) {
+	depth := 1
+	for j := i; j < len(src); {
+		var end int
+		var ok bool
+		switch {
+		case src[j] == '"':
+			end, ok = scanDoubleQuoted(src, j)
+		case src[j] == '\'':
+			end, ok = scanSingleQuoted(src, j)
+		case src[j] == '(':
+			depth++
+			j++
+			continue
+		case src[j] == ')':
+			depth--
+			j++
+			if depth == 0 {
+				return j, true
+			}
+			continue
+		default:
+			j++
+			continue
+		}
+		if !ok {
+			return len(src), false
+		}
+		j = end
+	}
+	return len(src), false
+}
+
+// Blank out strings and comments (keeping newlines and byte offsets) so
+// that only code is left for brace matching and command detection.
+func maskPowerShell(src string) string {
+	masked := []byte(src)
+	for _, seg := range scanPowerShell(src) {
+		if seg.Kind == psCode {
+			continue
+		}
+		for j := seg.Start; j < seg.Start+len(seg.Text); j++ {
+			if masked[j] != '\n' && masked[j] != '\r' {
+				masked[j] = ' '
+			}
+		}
+	}
+	return string(masked)
+}
+
+// 1-based line number of a byte offset
+func lineOf(src string, offset int) int {
+	return strings.Count(src[:offset], "\n") + 1
+}
+
+var functionNameRe = regexp.MustCompile(`(?i)^function\s+([A-Za-z0-9_:.-]+)`)
+
+// Name of the function declared at the start of a script block
+func functionName(body string) string {
+	if m := functionNameRe.FindStringSubmatch(strings.TrimSpace(body)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+var nestedFunctionRe = regexp.MustCompile(`(?im)(?:^|[\s;{}])function\s+([A-Za-z0-9_:.-]+)`)
+
+// Names of all functions declared anywhere in the source, including nested ones
+func declaredFunctions(src string) []string {
+	var names []string
+	for _, m := range nestedFunctionRe.FindAllStringSubmatch(maskPowerShell(src), -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
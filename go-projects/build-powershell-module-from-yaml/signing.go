@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Authenticode signatures for PowerShell scripts, produced without
+// Set-AuthenticodeSignature so they can be made on any build agent.
+//
+// The signature is a PKCS#7 SignedData blob appended to the script as
+// base64 in "# SIG #" comment lines. Its content is an SpcIndirectDataContent
+// naming the PowerShell SIP and carrying the SHA-256 of the script text
+// (UTF-16LE, without BOM and without the signature block).
+
+const (
+	sigBegin = "# SIG # Begin signature block"
+	sigEnd   = "# SIG # End signature block"
+)
+
+var (
+	oidSignedData            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectData       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidSpcSipInfo            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 30}
+	oidSpcStatementType      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 11}
+	oidSpcSpOpusInfo         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 12}
+	oidIndividualCodeSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 21}
+	oidContentType           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256                = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256       = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// {603BCC1F-4B59-4E08-B724-D2C6297EF351}, the PowerShell SIP, in GUID byte order
+var powershellSipGUID = []byte{0x1F, 0xCC, 0x3B, 0x60, 0x59, 0x4B, 0x08, 0x4E, 0xB7, 0x24, 0xD2, 0xC6, 0x29, 0x7E, 0xF3, 0x51}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+// Content is [0] EXPLICIT; see explicitContent
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// Wrap DER in a [0] EXPLICIT tag (encoding/asn1 writes RawValue.FullBytes verbatim)
+func explicitContent(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+type spcSipInfo struct {
+	Version   int
+	GUID      []byte
+	Reserved1 int
+	Reserved2 int
+	Reserved3 int
+	Reserved4 int
+	Reserved5 int
+}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest digestInfo
+}
+
+type issuerAndSerialNumber struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+// The authenticated attributes and certificates are [0] IMPLICIT; they are
+// kept as raw values with the tag set by hand.
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           algorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue
+	DigestEncryptionAlgorithm algorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []algorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue // SET OF value
+}
+
+var sha256Algorithm = algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+
+// Signing material loaded from a PFX file
+type signingIdentity struct {
+	Key   crypto.Signer
+	Cert  *x509.Certificate
+	Chain []*x509.Certificate
+}
+
+func loadPFX(path, password string) (*signingIdentity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, cert, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode PFX: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return &signingIdentity{Key: signer, Cert: cert, Chain: chain}, nil
+}
+
+// SHA-1 thumbprint as shown by Get-AuthenticodeSignature / certmgr
+func thumbprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Split a script into its content and the signature block (nil if unsigned)
+func splitSignature(data []byte) ([]byte, []byte) {
+	for _, marker := range []string{"\r\n" + sigBegin, "\n" + sigBegin} {
+		if idx := bytes.Index(data, []byte(marker)); idx >= 0 {
+			return data[:idx], data[idx+len(marker):]
+		}
+	}
+	return data, nil
+}
+
+// Script content without any signature block
+func stripSignature(data []byte) []byte {
+	content, _ := splitSignature(data)
+	return content
+}
+
+// Digest of the script text as the PowerShell SIP computes it
+func scriptDigest(content []byte) []byte {
+	text := string(bytes.TrimPrefix(content, utf8Bom))
+	units := utf16.Encode([]rune(text))
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[2*i:], u)
+	}
+	sum := sha256.Sum256(buf)
+	return sum[:]
+}
+
+func rawSet(elements ...[]byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(elements, nil)}
+}
+
+func newAttribute(oid asn1.ObjectIdentifier, value interface{}) ([]byte, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(attribute{Type: oid, Values: rawSet(der)})
+}
+
+// Build the PKCS#7 SignedData for a script's content
+func signScript(content []byte, id *signingIdentity) ([]byte, error) {
+	sipInfo, err := asn1.Marshal(spcSipInfo{Version: 65536, GUID: powershellSipGUID})
+	if err != nil {
+		return nil, err
+	}
+	indirect, err := asn1.Marshal(spcIndirectDataContent{
+		Data:          spcAttributeTypeAndOptionalValue{Type: oidSpcSipInfo, Value: asn1.RawValue{FullBytes: sipInfo}},
+		MessageDigest: digestInfo{Algorithm: sha256Algorithm, Digest: scriptDigest(content)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Authenticode digests the content octets of SpcIndirectDataContent, not its SEQUENCE header
+	var indirectSeq asn1.RawValue
+	if _, err := asn1.Unmarshal(indirect, &indirectSeq); err != nil {
+		return nil, err
+	}
+	contentDigest := sha256.Sum256(indirectSeq.Bytes)
+
+	var attrs [][]byte
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidSpcIndirectData},
+		{oidSigningTime, time.Now().UTC()},
+		{oidSpcSpOpusInfo, struct{}{}},
+		{oidSpcStatementType, []asn1.ObjectIdentifier{oidIndividualCodeSigning}},
+		{oidMessageDigest, contentDigest[:]},
+	} {
+		der, err := newAttribute(a.oid, a.value)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, der)
+	}
+	// DER requires SET OF elements in ascending byte order
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+
+	signedAttrs, err := asn1.Marshal(rawSet(attrs...))
+	if err != nil {
+		return nil, err
+	}
+	attrsDigest := sha256.Sum256(signedAttrs)
+
+	var encryptionAlgorithm algorithmIdentifier
+	switch id.Key.Public().(type) {
+	case *rsa.PublicKey:
+		encryptionAlgorithm = algorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		encryptionAlgorithm = algorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", id.Key.Public())
+	}
+	signature, err := id.Key.Sign(rand.Reader, attrsDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs [][]byte
+	for _, c := range append([]*x509.Certificate{id.Cert}, id.Chain...) {
+		certs = append(certs, c.Raw)
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []algorithmIdentifier{sha256Algorithm},
+		ContentInfo:      contentInfo{ContentType: oidSpcIndirectData, Content: explicitContent(indirect)},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certs, nil)},
+		SignerInfos: []signerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: id.Cert.RawIssuer}, Serial: id.Cert.SerialNumber},
+			DigestAlgorithm:           sha256Algorithm,
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(attrs, nil)},
+			DigestEncryptionAlgorithm: encryptionAlgorithm,
+			EncryptedDigest:           signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: explicitContent(sd)})
+}
+
+// Format PKCS#7 DER as the comment block PowerShell expects
+func signatureBlock(der []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString("\r\n" + sigBegin + "\r\n")
+	encoded := base64.StdEncoding.EncodeToString(der)
+	for len(encoded) > 0 {
+		n := min(64, len(encoded))
+		b.WriteString("# " + encoded[:n] + "\r\n")
+		encoded = encoded[n:]
+	}
+	b.WriteString(sigEnd + "\r\n")
+	return b.Bytes()
+}
+
+// Result of checking a script's signature
+type signatureStatus struct {
+	Signer     *x509.Certificate
+	Thumbprint string
+	SignedAt   time.Time
+	Trusted    bool // chains to a root in the system store
+}
+
+var errNotSigned = errors.New("file is not signed")
+
+// Check that the signature block is well formed, that its digest matches
+// the script content and that it was produced by the embedded certificate
+func verifyScriptSignature(data []byte) (*signatureStatus, error) {
+	content, block := splitSignature(data)
+	if block == nil {
+		return nil, errNotSigned
+	}
+
+	end := bytes.Index(block, []byte(sigEnd))
+	if end < 0 {
+		return nil, errors.New("signature block has no end marker")
+	}
+	var encoded strings.Builder
+	for _, line := range strings.Split(string(block[:end]), "\n") {
+		encoded.WriteString(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "#")))
+	}
+	der, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("signature block is not valid base64: %w", err)
+	}
+
+	var outer contentInfo
+	if _, err := asn1.Unmarshal(der, &outer); err != nil || !outer.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("signature block is not PKCS#7 signed data")
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(outer.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("cannot parse signed data: %w", err)
+	}
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectData) || len(sd.SignerInfos) != 1 {
+		return nil, errors.New("not an Authenticode signature")
+	}
+
+	// 1. The script digest must match the current content
+	var indirectSeq asn1.RawValue
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &indirectSeq); err != nil {
+		return nil, fmt.Errorf("cannot parse indirect data: %w", err)
+	}
+	var indirect spcIndirectDataContent
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &indirect); err != nil {
+		return nil, fmt.Errorf("cannot parse indirect data: %w", err)
+	}
+	if !indirect.MessageDigest.Algorithm.Algorithm.Equal(oidSHA256) {
+		return nil, fmt.Errorf("unsupported digest algorithm %v", indirect.MessageDigest.Algorithm.Algorithm)
+	}
+	if !bytes.Equal(indirect.MessageDigest.Digest, scriptDigest(content)) {
+		return nil, errors.New("content does not match the signature (file changed after signing)")
+	}
+
+	// 2. The signed attributes must cover that indirect data
+	si := sd.SignerInfos[0]
+	if si.AuthenticatedAttributes.Class != asn1.ClassContextSpecific || si.AuthenticatedAttributes.Tag != 0 {
+		return nil, errors.New("signature has no authenticated attributes")
+	}
+	status := &signatureStatus{}
+	var messageDigest []byte
+	rest := si.AuthenticatedAttributes.Bytes
+	for len(rest) > 0 {
+		var attr attribute
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, fmt.Errorf("cannot parse authenticated attributes: %w", err)
+		}
+		switch {
+		case attr.Type.Equal(oidMessageDigest):
+			asn1.Unmarshal(attr.Values.Bytes, &messageDigest)
+		case attr.Type.Equal(oidSigningTime):
+			asn1.Unmarshal(attr.Values.Bytes, &status.SignedAt)
+		}
+	}
+	indirectDigest := sha256.Sum256(indirectSeq.Bytes)
+	if !bytes.Equal(messageDigest, indirectDigest[:]) {
+		return nil, errors.New("message digest attribute does not match the signed content")
+	}
+
+	// 3. The signer certificate must have produced the signature
+	var certs []*x509.Certificate
+	for rest := sd.Certificates.Bytes; len(rest) > 0; {
+		var raw asn1.RawValue
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &raw); err != nil {
+			return nil, fmt.Errorf("cannot parse certificates: %w", err)
+		}
+		cert, err := x509.ParseCertificate(raw.FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerialNumber.Issuer.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerialNumber.Serial) == 0 {
+			status.Signer = c
+		}
+	}
+	if status.Signer == nil {
+		return nil, errors.New("signer certificate is not included in the signature")
+	}
+
+	signedAttrs, err := asn1.Marshal(rawSet(si.AuthenticatedAttributes.Bytes))
+	if err != nil {
+		return nil, err
+	}
+	algorithm := x509.SHA256WithRSA
+	if si.DigestEncryptionAlgorithm.Algorithm.Equal(oidECDSAWithSHA256) {
+		algorithm = x509.ECDSAWithSHA256
+	}
+	if err := status.Signer.CheckSignature(algorithm, signedAttrs, si.EncryptedDigest); err != nil {
+		return nil, fmt.Errorf("signature does not verify: %w", err)
+	}
+	status.Thumbprint = thumbprint(status.Signer)
+
+	// Trust is reported, not required: self-signed code signing certificates are common here
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+	_, err = status.Signer.Verify(x509.VerifyOptions{
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		CurrentTime:   status.SignedAt,
+	})
+	status.Trusted = err == nil
+	return status, nil
+}
+
+// Sign a file in place unless it already carries a valid signature from the same certificate
+func signFile(path string, id *signingIdentity) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if status, err := verifyScriptSignature(data); err == nil && status.Thumbprint == thumbprint(id.Cert) {
+		fmt.Println("⏭️ Already signed:", path)
+		return nil
+	}
+
+	content := stripSignature(data)
+	der, err := signScript(content, id)
+	if err != nil {
+		return fmt.Errorf("cannot sign %s: %w", path, err)
+	}
+	signed := append(append([]byte{}, content...), signatureBlock(der)...)
+	if err := os.WriteFile(path, signed, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("🔏 Signed: %s (%s)\n", path, thumbprint(id.Cert))
+	return nil
+}
+
+// verify: check the signatures of the generated module files
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	outputDir := fs.String("output", filepath.Join(repoDir(), "output"), "Directory containing the module files")
+	requireTrusted := fs.Bool("require-trusted", false, "Also fail if the signer does not chain to a trusted root")
+	fs.Usage = func() {
+		fmt.Println("Usage: build-powershell-module-from-yaml verify [flags] [file...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files = []string{
+			filepath.Join(*outputDir, moduleName+".psm1"),
+			filepath.Join(*outputDir, moduleName+".psd1"),
+		}
+	}
+
+	failed := false
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Printf("❌ %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		status, err := verifyScriptSignature(data)
+		if err != nil {
+			fmt.Printf("❌ %s: %v\n", path, err)
+			failed = true
+			continue
+		}
+		trust := "✅ trusted"
+		if !status.Trusted {
+			trust = "⚠️ not trusted by this machine"
+			if *requireTrusted {
+				failed = true
+			}
+		}
+		fmt.Printf("✅ %s: valid signature by %s (%s), signed %s, %s\n",
+			path, status.Signer.Subject.CommonName, status.Thumbprint, status.SignedAt.Local().Format(time.RFC3339), trust)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,248 @@
+package configprofile
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Which profiles apply on top of configuration_profile: those whose when:
+// matches this machine, then those asked for with --profile
+type Selection struct {
+	Profiles []string // from --profile, highest priority last
+	Hostname string
+	Domain   string // DNS domain the machine is joined to, if any
+}
+
+// ThisMachine selects profiles for the machine this runs on. profiles is the
+// comma-separated --profile value.
+func ThisMachine(profiles string) Selection {
+	sel := Selection{}
+	for _, name := range strings.Split(profiles, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			sel.Profiles = append(sel.Profiles, name)
+		}
+	}
+	sel.Hostname, _ = os.Hostname()
+	// Set by Windows for domain accounts; empty on workgroup machines
+	sel.Domain = os.Getenv("USERDNSDOMAIN")
+	return sel
+}
+
+// A profile under profiles:, before it is checked against the schema
+type profileEntry struct {
+	name    string
+	key     *yaml.Node
+	body    *yaml.Node // the profile without extends and when
+	extends string
+	when    map[string]string // hostname / domain → glob
+}
+
+var whenKeys = []string{"hostname", "domain"}
+
+// Parse checks config data for the selected profiles; name is used in
+// messages. Every profile is checked, not only the selected ones, so a
+// mistake in one machine's overrides shows up everywhere.
+func Parse(data []byte, name string, schema map[string]*Field, sel Selection) (*Profile, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	problems := &Problems{File: name}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		problems.add(&doc, "", "expected a mapping with configuration_profile")
+		return nil, problems
+	}
+
+	top := doc.Content[0]
+	var base, profilesNode *yaml.Node
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		key := top.Content[i]
+		switch key.Value {
+		case "configuration_profile":
+			base = top.Content[i+1]
+		case "profiles":
+			profilesNode = top.Content[i+1]
+		default:
+			problems.add(key, "", "unknown top-level key '%s'%s", key.Value, suggest(key.Value, []string{"configuration_profile", "profiles"}))
+		}
+	}
+	if base == nil {
+		problems.add(top, "", "configuration_profile not found")
+		return nil, problems
+	}
+
+	root := &Field{Fields: schema}
+	baseLayer := newLayer("configuration_profile", name)
+	baseLayer.walk(base, "", root, problems)
+
+	entries := readProfiles(profilesNode, problems)
+	layers := make(map[string]*layer, len(entries))
+	for _, entry := range entries {
+		l := newLayer("profiles."+entry.name, name)
+		l.walk(entry.body, "", root, problems)
+		layers[entry.name] = l
+	}
+	byName := make(map[string]*profileEntry, len(entries))
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		byName[entry.name] = entry
+		names = append(names, entry.name)
+	}
+	sort.Strings(names)
+	for _, entry := range entries {
+		if entry.extends != "" && byName[entry.extends] == nil {
+			problems.add(entry.key, "profiles."+entry.name, "extends unknown profile '%s'%s", entry.extends, suggest(entry.extends, names))
+		}
+	}
+	for _, requested := range sel.Profiles {
+		if byName[strings.ToLower(requested)] == nil {
+			problems.add(&yaml.Node{}, "", "--profile %s: no such profile%s", requested, suggest(strings.ToLower(requested), names))
+		}
+	}
+	if len(problems.List) > 0 {
+		sort.SliceStable(problems.List, func(i, j int) bool { return problems.List[i].Line < problems.List[j].Line })
+		return nil, problems
+	}
+
+	// Lowest priority first: the base, profiles matching this machine in
+	// file order, then --profile. Each brings its extends chain in front of it.
+	order := []*layer{baseLayer}
+	applied := make(map[string]bool)
+	add := func(entry *profileEntry, reason string) error {
+		var chain []*profileEntry
+		visiting := make(map[string]bool)
+		for e := entry; e != nil; e = byName[e.extends] {
+			if visiting[e.name] {
+				return fmt.Errorf("%s:%d: profiles.%s: extends itself through '%s'", name, e.key.Line, e.name, e.extends)
+			}
+			visiting[e.name] = true
+			chain = append([]*profileEntry{e}, chain...)
+		}
+		for _, e := range chain {
+			if applied[e.name] {
+				continue
+			}
+			applied[e.name] = true
+			l := layers[e.name]
+			l.reason = reason
+			if e != entry {
+				l.reason = "extended by " + entry.name
+			}
+			order = append(order, l)
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		if reason, ok := matches(entry, sel); ok {
+			if err := add(entry, reason); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, requested := range sel.Profiles {
+		if err := add(byName[strings.ToLower(requested)], "--profile"); err != nil {
+			return nil, err
+		}
+	}
+
+	profile := &Profile{
+		values:  make(map[string]string),
+		lists:   make(map[string][]string),
+		nodes:   make(map[string]*yaml.Node),
+		sources: make(map[string]*layer),
+	}
+	for _, l := range order {
+		profile.merge(l)
+	}
+	return profile, nil
+}
+
+// Split each profile into its extends, when and settings
+func readProfiles(node *yaml.Node, problems *Problems) []*profileEntry {
+	if node == nil {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		problems.add(node, "profiles", "expected profile names with their settings")
+		return nil
+	}
+
+	var entries []*profileEntry
+	seen := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		entry := &profileEntry{name: strings.ToLower(key.Value), key: key, when: make(map[string]string)}
+		where := "profiles." + entry.name
+		if line, ok := seen[entry.name]; ok {
+			problems.add(key, where, "defined twice (first on line %d)", line)
+			continue
+		}
+		seen[entry.name] = key.Line
+		if value.Kind != yaml.MappingNode {
+			problems.add(value, where, "expected settings, extends or when")
+			continue
+		}
+
+		entry.body = &yaml.Node{Kind: yaml.MappingNode, Line: value.Line}
+		for j := 0; j+1 < len(value.Content); j += 2 {
+			k, v := value.Content[j], value.Content[j+1]
+			switch strings.ToLower(k.Value) {
+			case "extends":
+				if v.Kind != yaml.ScalarNode || v.Value == "" {
+					problems.add(v, where+".extends", "expected a profile name")
+					continue
+				}
+				entry.extends = strings.ToLower(v.Value)
+			case "when":
+				if v.Kind != yaml.MappingNode {
+					problems.add(v, where+".when", "expected hostname and/or domain")
+					continue
+				}
+				for n := 0; n+1 < len(v.Content); n += 2 {
+					wk, wv := v.Content[n], v.Content[n+1]
+					selector := strings.ToLower(wk.Value)
+					if !containsFold(whenKeys, selector) {
+						problems.add(wk, where+".when."+wk.Value, "unknown selector%s", suggest(selector, whenKeys))
+						continue
+					}
+					if _, err := path.Match(wv.Value, ""); err != nil || wv.Kind != yaml.ScalarNode {
+						problems.add(wv, where+".when."+selector, "'%s' is not a valid pattern", wv.Value)
+						continue
+					}
+					entry.when[selector] = wv.Value
+				}
+			default:
+				entry.body.Content = append(entry.body.Content, k, v)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Whether a profile's when: matches the machine; all selectors have to match.
+// Patterns are globs (BUILD-*), compared case-insensitively.
+func matches(entry *profileEntry, sel Selection) (string, bool) {
+	if len(entry.when) == 0 {
+		return "", false
+	}
+	actual := map[string]string{"hostname": sel.Hostname, "domain": sel.Domain}
+	var reasons []string
+	for _, selector := range whenKeys {
+		pattern, ok := entry.when[selector]
+		if !ok {
+			continue
+		}
+		matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(actual[selector]))
+		if !matched {
+			return "", false
+		}
+		reasons = append(reasons, fmt.Sprintf("%s %s matches %s", selector, actual[selector], pattern))
+	}
+	return strings.Join(reasons, ", "), true
+}
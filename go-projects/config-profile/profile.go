@@ -0,0 +1,454 @@
+// Package configprofile loads the configuration_profile section of config.yaml
+// against a schema, with the profiles that apply to this machine merged over
+// it. Unknown keys and values are errors, reported together and with line
+// numbers, so a tool can refuse a config before it changes anything.
+package configprofile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// What a key under configuration_profile may hold. A field with Values and
+// Fields takes either a value (ssh: on) or a section.
+type Field struct {
+	Values []string           // allowed values, matched case-insensitively
+	Check  func(string) error // accepts values not in Values (e.g. a date pattern)
+	Text   bool               // any text
+	List   bool               // a list of such values (a single value is a list of one)
+	Fields map[string]*Field  // a section and its keys
+	Each   *Field             // a section with keys of its own choosing (user names), each holding this
+	Open   bool               // a section another tool checks; anything goes
+}
+
+// Whether the field takes a plain value
+func (f *Field) scalar() bool {
+	return f.Text || f.Values != nil || f.Check != nil
+}
+
+// A field that is on or off (true/yes and false/no are accepted too)
+func Switch() *Field {
+	return &Field{Values: []string{"on", "off"}}
+}
+
+// The keys of configuration_profile every tool knows about. Sections whose
+// keys come from one tool's own data (customize-file-explorer's settings.yaml)
+// are open here; that tool fills them in before loading.
+func Schema() map[string]*Field {
+	return map[string]*Field{
+		"explorer":           {Open: true},
+		"date time settings": {Open: true},
+		"powershell modules": {Text: true},
+		"ssh":                sshField(),
+	}
+}
+
+// Section returns the named section of schema, replacing an open one with an
+// empty section so its keys can be declared
+func Section(schema map[string]*Field, path string) map[string]*Field {
+	fields := schema
+	for _, part := range strings.Split(path, ".") {
+		part = strings.ToLower(part)
+		field, ok := fields[part]
+		if !ok || field.Fields == nil {
+			field = &Field{Fields: make(map[string]*Field)}
+			fields[part] = field
+		}
+		fields = field.Fields
+	}
+	return fields
+}
+
+// One thing wrong with the config
+type Problem struct {
+	Line    int
+	Key     string // dotted path under configuration_profile
+	Message string
+}
+
+// Every problem found in a config, in file order
+type Problems struct {
+	File string
+	List []Problem
+}
+
+func (p *Problems) Error() string {
+	lines := make([]string, len(p.List))
+	for i, problem := range p.List {
+		if problem.Line == 0 {
+			lines[i] = fmt.Sprintf("%s: %s", p.File, problem.Message)
+			continue
+		}
+		if problem.Key == "" {
+			lines[i] = fmt.Sprintf("%s:%d: %s", p.File, problem.Line, problem.Message)
+			continue
+		}
+		lines[i] = fmt.Sprintf("%s:%d: %s: %s", p.File, problem.Line, problem.Key, problem.Message)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (p *Problems) add(node *yaml.Node, key, format string, args ...interface{}) {
+	p.List = append(p.List, Problem{Line: node.Line, Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+// A checked configuration_profile, with any selected profiles merged over it.
+// Values are normalized to the schema's spelling (yes → on, true → shown, ...);
+// keys are looked up case-insensitively.
+type Profile struct {
+	values  map[string]string
+	lists   map[string][]string
+	nodes   map[string]*yaml.Node
+	sources map[string]*layer
+	layers  []*layer
+}
+
+// Get returns the value at a dotted path such as "explorer.dark_mode"
+func (p *Profile) Get(path string) (string, bool) {
+	value, ok := p.values[strings.ToLower(path)]
+	return value, ok
+}
+
+// GetList returns the list at a dotted path such as "ssh.allow users"
+func (p *Profile) GetList(path string) ([]string, bool) {
+	list, ok := p.lists[strings.ToLower(path)]
+	return list, ok
+}
+
+// Has reports whether the path is in the config, as a value or a section
+func (p *Profile) Has(path string) bool {
+	_, ok := p.nodes[strings.ToLower(path)]
+	return ok
+}
+
+// Line returns where the path is set, or 0
+func (p *Profile) Line(path string) int {
+	if node, ok := p.nodes[strings.ToLower(path)]; ok {
+		return node.Line
+	}
+	return 0
+}
+
+// Source describes where the value at path came from, e.g.
+// "profiles.laptop (config.yaml:31)"
+func (p *Profile) Source(path string) string {
+	path = strings.ToLower(path)
+	l, ok := p.sources[path]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%s (%s:%d)", l.name, l.file, p.nodes[path].Line)
+}
+
+// Paths returns the path of every value and list, sorted
+func (p *Profile) Paths() []string {
+	paths := make([]string, 0, len(p.values)+len(p.lists))
+	for path := range p.values {
+		paths = append(paths, path)
+	}
+	for path := range p.lists {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Lay l over the profile. What l sets replaces what was there whole: a list
+// is not appended to, and ssh: off replaces an ssh: section (and the other
+// way round).
+func (p *Profile) merge(l *layer) {
+	p.layers = append(p.layers, l)
+	set := func(path string) {
+		for _, existing := range p.Paths() {
+			if existing == path || strings.HasPrefix(existing, path+".") || strings.HasPrefix(path, existing+".") {
+				delete(p.values, existing)
+				delete(p.lists, existing)
+				delete(p.sources, existing)
+			}
+		}
+		for existing := range p.nodes {
+			if strings.HasPrefix(existing, path+".") {
+				delete(p.nodes, existing)
+			}
+		}
+		p.sources[path] = l
+	}
+	for path, value := range l.values {
+		set(path)
+		p.values[path] = value
+	}
+	for path, list := range l.lists {
+		set(path)
+		p.lists[path] = list
+	}
+	for path, node := range l.nodes {
+		p.nodes[path] = node
+	}
+}
+
+// Layers describes the layers merged, lowest priority first, and why each applies
+func (p *Profile) Layers() []string {
+	described := make([]string, len(p.layers))
+	for i, l := range p.layers {
+		described[i] = l.name
+		if l.reason != "" {
+			described[i] += " (" + l.reason + ")"
+		}
+	}
+	return described
+}
+
+// Load reads and checks the config file at path for the selected profiles
+func Load(path string, schema map[string]*Field, sel Selection) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, path, schema, sel)
+}
+
+// One set of values: configuration_profile itself or one of profiles
+type layer struct {
+	name   string // configuration_profile or profiles.<name>
+	file   string
+	reason string // why it applies
+	values map[string]string
+	lists  map[string][]string
+	nodes  map[string]*yaml.Node
+}
+
+func newLayer(name, file string) *layer {
+	return &layer{
+		name:   name,
+		file:   file,
+		values: make(map[string]string),
+		lists:  make(map[string][]string),
+		nodes:  make(map[string]*yaml.Node),
+	}
+}
+
+// The key a problem is reported under
+func (l *layer) key(path string) string {
+	if l.name == "configuration_profile" {
+		return path
+	}
+	return join(l.name, path)
+}
+
+func (l *layer) walk(node *yaml.Node, path string, field *Field, problems *Problems) {
+	if path != "" {
+		l.nodes[path] = node
+	}
+
+	switch {
+	case field.List:
+		items := []*yaml.Node{node}
+		if node.Kind == yaml.SequenceNode {
+			items = node.Content
+		}
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			if item.Kind != yaml.ScalarNode || item.Tag == "!!null" {
+				problems.add(item, l.key(path), "expected a list of values")
+				return
+			}
+			value, err := field.normalize(item.Value)
+			if err != nil {
+				problems.add(item, l.key(path), "%v", err)
+				return
+			}
+			list = append(list, value)
+		}
+		l.lists[path] = list
+
+	case node.Kind == yaml.ScalarNode && field.scalar():
+		l.scalar(node, path, field, problems)
+
+	case field.Open:
+		// Someone else's section: record it as it is
+		switch node.Kind {
+		case yaml.ScalarNode:
+			l.values[path] = node.Value
+		case yaml.SequenceNode:
+			list := make([]string, 0, len(node.Content))
+			for _, item := range node.Content {
+				list = append(list, item.Value)
+			}
+			l.lists[path] = list
+		default:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				l.walk(node.Content[i+1], join(path, strings.ToLower(node.Content[i].Value)), &Field{Open: true}, problems)
+			}
+		}
+
+	case field.Each != nil:
+		if node.Kind != yaml.MappingNode {
+			problems.add(node, l.key(path), "expected a section with keys, not '%s'", node.Value)
+			return
+		}
+		seen := make(map[string]int)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			name := strings.ToLower(key.Value)
+			if line, ok := seen[name]; ok {
+				problems.add(key, l.key(join(path, key.Value)), "set twice (first on line %d)", line)
+				continue
+			}
+			seen[name] = key.Line
+			if strings.TrimSpace(name) == "" || strings.Contains(name, ".") {
+				problems.add(key, l.key(join(path, key.Value)), "'%s' cannot be used as a name here", key.Value)
+				continue
+			}
+			l.walk(node.Content[i+1], join(path, name), field.Each, problems)
+		}
+
+	case field.Fields != nil:
+		if node.Kind != yaml.MappingNode {
+			problems.add(node, l.key(path), "expected a section with keys, not '%s'", node.Value)
+			return
+		}
+		names := make([]string, 0, len(field.Fields))
+		for name := range field.Fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		seen := make(map[string]int)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i]
+			name := strings.ToLower(key.Value)
+			if line, ok := seen[name]; ok {
+				problems.add(key, l.key(join(path, key.Value)), "set twice (first on line %d)", line)
+				continue
+			}
+			seen[name] = key.Line
+			child, ok := field.Fields[name]
+			if !ok {
+				problems.add(key, l.key(join(path, key.Value)), "unknown key%s", suggest(name, names))
+				continue
+			}
+			l.walk(node.Content[i+1], join(path, name), child, problems)
+		}
+
+	default:
+		if node.Kind != yaml.ScalarNode {
+			problems.add(node, l.key(path), "expected a value, not a section or list")
+			return
+		}
+		l.scalar(node, path, field, problems)
+	}
+}
+
+func (l *layer) scalar(node *yaml.Node, path string, field *Field, problems *Problems) {
+	if node.Tag == "!!null" {
+		problems.add(node, l.key(path), "has no value")
+		return
+	}
+	value, err := field.normalize(node.Value)
+	if err != nil {
+		problems.add(node, l.key(path), "%v", err)
+		return
+	}
+	l.values[path] = value
+}
+
+func join(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// YAML booleans and the words used for them in config.yaml
+var (
+	truthy = []string{"true", "on", "yes", "shown", "enabled"}
+	falsy  = []string{"false", "off", "no", "hidden", "disabled"}
+)
+
+func (f *Field) normalize(value string) (string, error) {
+	if f.Text {
+		return value, nil
+	}
+	for _, allowed := range f.Values {
+		if strings.EqualFold(value, allowed) {
+			return allowed, nil
+		}
+	}
+	// true for a shown/hidden setting means shown, and so on, as long as
+	// only one allowed value means the same
+	for _, group := range [][]string{truthy, falsy} {
+		if !containsFold(group, value) {
+			continue
+		}
+		var matches []string
+		for _, allowed := range f.Values {
+			if containsFold(group, allowed) {
+				matches = append(matches, allowed)
+			}
+		}
+		if len(matches) == 1 {
+			return matches[0], nil
+		}
+	}
+	if f.Check != nil {
+		if err := f.Check(value); err != nil {
+			return "", err
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("'%s' is not one of: %s%s", value, strings.Join(f.Values, ", "), suggest(strings.ToLower(value), f.Values))
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// " (did you mean 'x'?)" for the closest candidate, if any is close enough
+func suggest(value string, candidates []string) string {
+	best, bestDistance := "", -1
+	for _, candidate := range candidates {
+		d := distance(strings.ToLower(value), strings.ToLower(candidate))
+		if bestDistance < 0 || d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	limit := len([]rune(value)) / 3
+	if limit < 2 {
+		limit = 2
+	}
+	if best == "" || bestDistance > limit {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean '%s'?)", best)
+}
+
+// Levenshtein distance
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
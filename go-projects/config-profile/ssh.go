@@ -0,0 +1,111 @@
+package configprofile
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ssh: is on, off, or a section describing the server:
+//
+//	ssh:
+//	  port: 2222
+//	  listen addresses: [0.0.0.0]
+//	  password authentication: off
+//	  public key authentication: on
+//	  allow groups: [administrators, ssh-users]
+//	  default shell: pwsh
+//	  subsystems:
+//	    sftp: sftp-server.exe
+//	  authorized keys:
+//	    administrators:
+//	      - ssh-ed25519 AAAAC3Nza... alice@laptop
+//	      - https://github.com/alice.keys
+//	    bob:
+//	      - C:\keys\bob
+func sshField() *Field {
+	return &Field{
+		Values: []string{"on", "off"},
+		Fields: map[string]*Field{
+			"enabled":                   Switch(),
+			"port":                      {Check: checkPort},
+			"listen addresses":          {List: true, Check: checkListenAddress},
+			"password authentication":   Switch(),
+			"public key authentication": Switch(),
+			"allow users":               {List: true, Check: checkPrincipal},
+			"allow groups":              {List: true, Check: checkPrincipal},
+			"default shell":             {Values: []string{"pwsh", "powershell", "cmd"}, Check: checkShellPath},
+			"subsystems":                {Open: true},
+			"authorized keys":           {Each: &Field{List: true, Check: checkKeySource}},
+		},
+	}
+}
+
+func checkPort(value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("'%s' is not a port (1-65535)", value)
+	}
+	return nil
+}
+
+// An IP address, optionally with a port: 0.0.0.0, ::, 192.168.1.10:2222, [::1]:2222
+func checkListenAddress(value string) error {
+	if net.ParseIP(value) != nil {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || net.ParseIP(host) == nil {
+		return fmt.Errorf("'%s' is not an IP address (write [address]:port to add a port)", value)
+	}
+	return checkPort(port)
+}
+
+// A user or group pattern for AllowUsers/AllowGroups, e.g. alice, domain\alice, *@host
+func checkPrincipal(value string) error {
+	if strings.TrimSpace(value) == "" || strings.ContainsAny(value, "\"\r\n") {
+		return fmt.Errorf("'%s' is not a user or group name", value)
+	}
+	return nil
+}
+
+var shellPathRe = regexp.MustCompile(`(?i)^[a-z]:\\.+\.exe$`)
+
+func checkShellPath(value string) error {
+	if !shellPathRe.MatchString(value) {
+		return fmt.Errorf("'%s' is not pwsh, powershell, cmd or the full path of an .exe", value)
+	}
+	return nil
+}
+
+// A key, possibly after authorized_keys options (from="10.0.0.*" ssh-ed25519 ...)
+var keyTypeRe = regexp.MustCompile(`(^|\s)(ssh-|ecdsa-|sk-)[a-z0-9@.-]+\s+[A-Za-z0-9+/=]+`)
+
+// IsKeyLine reports whether an ssh.authorized keys entry is a key itself
+// rather than a URL or path to read keys from
+func IsKeyLine(value string) bool {
+	return keyTypeRe.MatchString(value)
+}
+
+// C:\... or \\server\share\...
+var windowsPathRe = regexp.MustCompile(`^([A-Za-z]:\\|\\\\)`)
+
+// A public key line, an https:// URL serving keys, or the full path of a key
+// file or a directory of .pub files. The keys themselves are parsed by
+// enable-ssh when it reads them.
+func checkKeySource(value string) error {
+	switch {
+	case IsKeyLine(value):
+		return nil
+	case strings.HasPrefix(value, "http://"):
+		return fmt.Errorf("'%s': fetch keys over https://, not http://", value)
+	case strings.HasPrefix(value, "https://"):
+		return nil
+	case filepath.IsAbs(value) || windowsPathRe.MatchString(value):
+		return nil
+	}
+	return fmt.Errorf("'%s' is not a public key, an https:// URL or a full path", value)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"jsonc"
+)
+
+// The keybindings shipped with the tool; --config replaces them
+//
+//go:embed keybindings.yaml
+var builtinKeybindings []byte
+
+// What keybindings.json should have, as described in keybindings.yaml
+type desiredKeybindings struct {
+	Keybindings []Keybinding `yaml:"keybindings"`
+	Conflicts   string       `yaml:"conflicts"`
+}
+
+// What to do about a binding of the same key and when clause to another command
+var conflictPolicies = []string{"warn", "skip", "replace"}
+
+// readDesired reads the desired keybindings from path, or the built-in ones if path is empty
+func readDesired(path string) (*desiredKeybindings, error) {
+	d := desiredKeybindings{Conflicts: "warn"}
+	if err := jsonc.LoadYAML(path, builtinKeybindings, &d); err != nil {
+		return nil, err
+	}
+	if !slices.Contains(conflictPolicies, d.Conflicts) {
+		return nil, fmt.Errorf("conflicts: '%s' is not one of: %s", d.Conflicts, strings.Join(conflictPolicies, ", "))
+	}
+
+	for i, b := range d.Keybindings {
+		if b.Key == "" || b.Command == "" {
+			return nil, fmt.Errorf("keybindings[%d]: key and command are required", i)
+		}
+		// The list must not fight with itself
+		for _, other := range d.Keybindings[:i] {
+			if conflicts(b, other) {
+				return nil, fmt.Errorf("keybindings: %s is bound to both %s and %s", describe(b), other.Command, b.Command)
+			}
+		}
+	}
+	return &d, nil
+}
+
+// normalizeKey lowercases a key chord and drops spaces, as VS Code compares them
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.Join(strings.Fields(key), ""))
+}
+
+// normalizeWhen collapses the whitespace in a when clause
+func normalizeWhen(when string) string {
+	return strings.Join(strings.Fields(when), " ")
+}
+
+// same reports whether a and b are the same keybinding
+func same(a, b Keybinding) bool {
+	return normalizeKey(a.Key) == normalizeKey(b.Key) &&
+		a.Command == b.Command &&
+		normalizeWhen(a.When) == normalizeWhen(b.When) &&
+		reflect.DeepEqual(a.Args, b.Args)
+}
+
+// conflicts reports whether a and b bind the same key, under the same when
+// clause, to different commands. Unbinding ("-command") never conflicts.
+func conflicts(a, b Keybinding) bool {
+	if strings.HasPrefix(a.Command, "-") || strings.HasPrefix(b.Command, "-") {
+		return false
+	}
+	return normalizeKey(a.Key) == normalizeKey(b.Key) &&
+		normalizeWhen(a.When) == normalizeWhen(b.When) &&
+		(a.Command != b.Command || !reflect.DeepEqual(a.Args, b.Args))
+}
+
+// describe names the key and when clause of b for messages
+func describe(b Keybinding) string {
+	if b.When == "" {
+		return b.Key
+	}
+	return fmt.Sprintf("%s (when %s)", b.Key, b.When)
+}
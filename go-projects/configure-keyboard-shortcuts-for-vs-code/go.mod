@@ -1,3 +1,10 @@
 module configure-keyboard-shortcuts-for-vs-code
 
 go 1.24.4
+
+require jsonc v0.0.0
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+// Shared JSON-with-comments editor, kept next to the tools that use it
+replace jsonc => ../jsonc
@@ -1,38 +1,63 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
+
+	"jsonc"
 )
 
+// A keybinding as reconcile compares them; entries read from
+// keybindings.json are kept as they are, members it does not know included
 type Keybinding struct {
-	Key     string `json:"key"`
-	Command string `json:"command"`
-	When    string `json:"when,omitempty"`
+	Key     string      `json:"key" yaml:"key"`
+	Command string      `json:"command" yaml:"command"`
+	When    string      `json:"when,omitempty" yaml:"when"`
+	Args    interface{} `json:"args,omitempty" yaml:"args"`
 }
 
 func main() {
-	appData := os.Getenv("APPDATA")
-	if appData == "" {
-		fmt.Println("❌ APPDATA environment variable not set.")
-		return
+	configPath := flag.String("config", "", "Desired keybindings (default: the built-in keybindings.yaml)")
+	keybindingsFlag := flag.String("keybindings", "", "keybindings.json to update (default: VS Code's, under APPDATA)")
+	flag.Parse()
+
+	desired, err := readDesired(*configPath)
+	if err != nil {
+		fmt.Printf("❌ Failed to read desired keybindings: %v\n", err)
+		os.Exit(1)
 	}
 
-	// 💡 You can open keyboard shortcuts with:
-	// code $env:appdata\Code\User\keybindings.json
-	keybindingsPath := filepath.Join(appData, "Code", "User", "keybindings.json")
+	keybindingsPath := *keybindingsFlag
+	if keybindingsPath == "" {
+		appData := os.Getenv("APPDATA")
+		if appData == "" {
+			fmt.Println("❌ APPDATA environment variable not set.")
+			os.Exit(1)
+		}
+
+		// 💡 You can open keyboard shortcuts with:
+		// code $env:appdata\Code\User\keybindings.json
+		keybindingsPath = filepath.Join(appData, "Code", "User", "keybindings.json")
+	}
+	// The bindings this tool added on earlier runs
+	markerPath := filepath.Join(filepath.Dir(keybindingsPath), "keybindings.managed.json")
 
-	var existingBindings []Keybinding
+	var existingBindings []map[string]interface{}
+	var data []byte
 
 	// Check if file exists
 	if _, err := os.Stat(keybindingsPath); err == nil {
-		data, err := os.ReadFile(keybindingsPath)
+		data, err = os.ReadFile(keybindingsPath)
 		if err == nil && len(data) > 0 {
-			if err := json.Unmarshal(data, &existingBindings); err != nil {
+			// keybindings.json may have comments and trailing commas
+			if err := jsonc.Unmarshal(data, &existingBindings); err != nil {
 				fmt.Printf("❌ Failed to parse existing keybindings.json: %v\n", err)
-				return
+				os.Exit(1)
 			}
 		}
 	} else if os.IsNotExist(err) {
@@ -40,54 +65,174 @@ func main() {
 		dir := filepath.Dir(keybindingsPath)
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			fmt.Printf("❌ Failed to create directory %s: %v\n", dir, err)
-			return
+			os.Exit(1)
 		}
 		// Continue with empty keybindings list
 		fmt.Println("ℹ️ keybindings.json does not exist, creating a new one.")
 	} else {
 		fmt.Printf("❌ Failed to stat keybindings.json: %v\n", err)
-		return
+		os.Exit(1)
 	}
 
-	// Desired keybindings
-	newBindings := []Keybinding{
-		{
-			Key:     "ctrl+a",
-			Command: "workbench.action.terminal.selectAll",
-			When:    "terminalFocus",
-		},
-		{
-			Key:     "ctrl+shift+a",
-			Command: "workbench.action.terminal.copySelectionAsHtml",
-			When:    "terminalFocus",
-		},
+	var previous []Keybinding
+	if marker, err := os.ReadFile(markerPath); err == nil {
+		if err := json.Unmarshal(marker, &previous); err != nil {
+			fmt.Printf("❌ Failed to parse %s: %v\n", markerPath, err)
+			os.Exit(1)
+		}
+	} else if !os.IsNotExist(err) {
+		fmt.Printf("❌ Failed to read %s: %v\n", markerPath, err)
+		os.Exit(1)
+	}
+
+	bindings, managed := reconcile(existingBindings, previous, desired)
+
+	// Write updated list, editing only what changed so comments survive
+	output, err := jsonc.Patch(data, bindings)
+	if err != nil {
+		fmt.Printf("❌ Failed to update keybindings: %v\n", err)
+		os.Exit(1)
 	}
 
-	// Only add new bindings if not already present
-	for _, newB := range newBindings {
-		found := false
-		for _, existingB := range existingBindings {
-			if existingB.Key == newB.Key && existingB.Command == newB.Command && existingB.When == newB.When {
-				found = true
-				break
+	if data != nil && bytes.Equal(output, data) {
+		fmt.Printf("✔️ Already up to date: %s\n", keybindingsPath)
+	} else {
+		// Backup original if it existed
+		if data != nil {
+			if err := backup(keybindingsPath, data); err != nil {
+				fmt.Printf("⚠️ Failed to backup original keybindings.json: %v\n", err)
 			}
 		}
-		if !found {
-			existingBindings = append(existingBindings, newB)
+		if err := writeAtomic(keybindingsPath, output); err != nil {
+			fmt.Printf("❌ Failed to write keybindings.json: %v\n", err)
+			os.Exit(1)
 		}
+		fmt.Printf("✅ Successfully updated: %s\n", keybindingsPath)
 	}
 
-	// Write updated list
-	output, err := json.MarshalIndent(existingBindings, "", "    ")
+	// Remember what is ours for the next run
+	if managed == nil {
+		managed = []Keybinding{}
+	}
+	marker, err := json.MarshalIndent(managed, "", "  ")
 	if err != nil {
-		fmt.Printf("❌ Failed to marshal keybindings: %v\n", err)
-		return
+		fmt.Printf("❌ Failed to encode %s: %v\n", markerPath, err)
+		os.Exit(1)
 	}
+	if err := writeAtomic(markerPath, append(marker, '\n')); err != nil {
+		fmt.Printf("❌ Failed to write %s: %v\n", markerPath, err)
+		os.Exit(1)
+	}
+}
 
-	if err := os.WriteFile(keybindingsPath, output, 0644); err != nil {
-		fmt.Printf("❌ Failed to write keybindings.json: %v\n", err)
-		return
+// reconcile brings existing, the entries of keybindings.json, in line with
+// desired: bindings added on an earlier run (previous) that are no longer
+// wanted are removed, missing ones are added, and bindings of the same key
+// and when clause to other commands are reported and handled as desired
+// says. It returns the new list and the bindings the tool now manages.
+func reconcile(existing []map[string]interface{}, previous []Keybinding, desired *desiredKeybindings) ([]interface{}, []Keybinding) {
+	wanted := func(b Keybinding) bool {
+		for _, d := range desired.Keybindings {
+			if same(b, d) {
+				return true
+			}
+		}
+		return false
+	}
+	ours := func(b Keybinding) bool {
+		for _, p := range previous {
+			if same(b, p) {
+				return true
+			}
+		}
+		return false
 	}
 
-	fmt.Printf("✅ Successfully updated: %s\n", keybindingsPath)
+	// 1. Drop what an earlier run added and the list no longer has
+	var bindings []Keybinding
+	entries := []interface{}{} // as they go back into the file, [] rather than null if none are left
+	for _, entry := range existing {
+		b := asKeybinding(entry)
+		if ours(b) && !wanted(b) {
+			fmt.Printf("➖ Removed %s → %s\n", describe(b), b.Command)
+			continue
+		}
+		bindings = append(bindings, b)
+		entries = append(entries, entry)
+	}
+
+	// 2. Add the rest, minding bindings of the same key
+	var managed []Keybinding
+	for _, want := range desired.Keybindings {
+		present := false
+		for _, b := range bindings {
+			present = present || same(b, want)
+		}
+
+		skip := false
+		kept := bindings[:0:0]
+		keptEntries := entries[:0:0]
+		for i, b := range bindings {
+			if !conflicts(b, want) {
+				kept = append(kept, b)
+				keptEntries = append(keptEntries, entries[i])
+				continue
+			}
+			switch {
+			case desired.Conflicts == "replace":
+				fmt.Printf("⚠️ Conflict: %s was bound to %s, replacing it with %s\n", describe(want), b.Command, want.Command)
+			case desired.Conflicts == "skip" && !present:
+				fmt.Printf("⚠️ Conflict: %s is bound to %s, not adding %s\n", describe(want), b.Command, want.Command)
+				skip = true
+				kept = append(kept, b)
+				keptEntries = append(keptEntries, entries[i])
+			default:
+				fmt.Printf("⚠️ Conflict: %s is also bound to %s and %s\n", describe(want), b.Command, want.Command)
+				kept = append(kept, b)
+				keptEntries = append(keptEntries, entries[i])
+			}
+		}
+		bindings, entries = kept, keptEntries
+		if present {
+			if ours(want) {
+				managed = append(managed, want)
+			}
+			continue
+		}
+		if skip {
+			continue
+		}
+		fmt.Printf("➕ Added %s → %s\n", describe(want), want.Command)
+		bindings = append(bindings, want)
+		entries = append(entries, want)
+		managed = append(managed, want)
+	}
+	return entries, managed
+}
+
+// asKeybinding reads the members of a keybindings.json entry reconcile compares
+func asKeybinding(entry map[string]interface{}) Keybinding {
+	b := Keybinding{Args: entry["args"]}
+	b.Key, _ = entry["key"].(string)
+	b.Command, _ = entry["command"].(string)
+	b.When, _ = entry["when"].(string)
+	return b
+}
+
+func backup(origPath string, data []byte) error {
+	dir := filepath.Dir(origPath)
+	base := filepath.Base(origPath)
+	ts := time.Now().Format("20060102_150405")
+	bakName := fmt.Sprintf("%s.bak.%s", base, ts)
+	bakPath := filepath.Join(dir, bakName)
+	return os.WriteFile(bakPath, data, 0o644)
+}
+
+// Atomic write: temp file -> rename
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
 }
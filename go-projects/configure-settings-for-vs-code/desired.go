@@ -0,0 +1,71 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"jsonc"
+)
+
+// The settings shipped with the tool; --config replaces them
+//
+//go:embed vscode.yaml
+var builtinSettings []byte
+
+// What settings.json should say, as described in vscode.yaml
+type desiredSettings struct {
+	Settings map[string]interface{} `yaml:"settings"`
+	Remove   []string               `yaml:"remove"`
+	Arrays   map[string]string      `yaml:"arrays"`
+}
+
+// How a list merges with the one already there
+var arrayStrategies = []string{"replace", "append", "unique"}
+
+// readDesired reads the desired settings from path, or the built-in ones if path is empty
+func readDesired(path string) (*desiredSettings, error) {
+	var d desiredSettings
+	if err := jsonc.LoadYAML(path, builtinSettings, &d); err != nil {
+		return nil, err
+	}
+	for key, strategy := range d.Arrays {
+		if !slices.Contains(arrayStrategies, strategy) {
+			return nil, fmt.Errorf("arrays: %s: '%s' is not one of: %s", key, strategy, strings.Join(arrayStrategies, ", "))
+		}
+	}
+	d.Settings = jsonc.ExpandEnv(d.Settings).(map[string]interface{})
+	return &d, nil
+}
+
+// apply merges the desired settings into cfg, the parsed settings.json:
+// objects are merged key by key, null removes a key, and lists follow the
+// strategy for their key
+func (d *desiredSettings) apply(cfg map[string]interface{}) {
+	jsonc.Merge(cfg, d.Settings, d.mergeList)
+	for _, key := range d.Remove {
+		delete(cfg, key)
+	}
+}
+
+func (d *desiredSettings) mergeList(key string, have, want []interface{}) []interface{} {
+	switch d.Arrays[key] {
+	case "append":
+		return append(append([]interface{}{}, have...), want...)
+	case "unique":
+		merged := append([]interface{}{}, have...)
+		for _, item := range want {
+			found := false
+			for _, existing := range merged {
+				found = found || reflect.DeepEqual(existing, item)
+			}
+			if !found {
+				merged = append(merged, item)
+			}
+		}
+		return merged
+	}
+	return want
+}
@@ -2,113 +2,134 @@
 
 // To see VS Code settings, use:
 // PowerShell: code $env:APPDATA\Code\User\settings.json
+// (Insiders: "Code - Insiders", VSCodium: VSCodium; profiles keep theirs in
+// User\profiles\<id>\settings.json)
 
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
+
+	"jsonc"
 )
 
 func main() {
-	// Get VS Code settings.json path
-	path, err := settingsPath()
+	configPath := flag.String("config", "", "Desired settings (default: the built-in vscode.yaml)")
+	variant := flag.String("variant", "all", "VS Code builds to update: all (those that have run here), or any of code, insiders, codium (comma-separated)")
+	profiles := flag.String("profiles", "", "VS Code profiles to update as well, by name or id (comma-separated, or all)")
+	workspaces := flag.String("workspace", "", "Workspace folders whose .vscode/settings.json to update instead of the user settings (comma-separated)")
+	flag.Parse()
+
+	desired, err := readDesired(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "failed to read desired settings: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Read existing settings.json if present
-	var cfg map[string]interface{}
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
-			cfg = make(map[string]interface{}) // No file, start fresh
-		} else {
-			fmt.Fprintf(os.Stderr, "failed to read settings.json: %v\n", err)
-			os.Exit(1)
+	// Get the settings.json files to update
+	var targets []target
+	if *workspaces != "" {
+		for _, dir := range strings.Split(*workspaces, ",") {
+			dir = strings.TrimSpace(dir)
+			targets = append(targets, target{Label: "workspace", Settings: filepath.Join(dir, ".vscode", "settings.json")})
 		}
 	} else {
-		if err := json.Unmarshal(data, &cfg); err != nil {
-			fmt.Fprintf(os.Stderr, "failed to parse existing JSON: %v\n", err)
+		users, err := userTargets(*variant)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
+		for _, user := range users {
+			targets = append(targets, user)
+			if *profiles == "" {
+				continue
+			}
+			found, err := profileTargets(user, *profiles)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			targets = append(targets, found...)
+		}
 	}
 
-	// Backup original if it existed
-	if data != nil {
-		if err := backup(path, data); err != nil {
-			fmt.Fprintf(os.Stderr, "warning: failed to backup original settings.json: %v\n", err)
+	// Report on every file, then fail if any of them did
+	failed := false
+	for _, t := range targets {
+		changed, err := update(t.Settings, desired)
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "❌ %s: %s: %v\n", t.Label, t.Settings, err)
+			failed = true
+		case changed:
+			fmt.Printf("✅ %s: %s updated\n", t.Label, t.Settings)
+		default:
+			fmt.Printf("✔️ %s: %s already up to date\n", t.Label, t.Settings)
 		}
 	}
-
-	// Get full Desktop path (e.g., C:\Users\peter\Desktop)
-	desktopPath, err := getResolvedDesktopPath()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to determine desktop path: %v\n", err)
+	if failed {
 		os.Exit(1)
 	}
+}
 
-	// Desired settings to insert/overwrite
-	cfg["files.autoSave"] = "afterDelay"
-	cfg["powershell.cwd"] = desktopPath
-	cfg["terminal.integrated.cwd"] = desktopPath
-	cfg["terminal.integrated.enableMultiLinePasteWarning"] = "never"
-	cfg["terminal.integrated.persistentSessionScrollback"] = 10000000
-	cfg["terminal.integrated.rightClickBehavior"] = "default"
-	cfg["terminal.integrated.scrollback"] = 10000000
-	cfg["workbench.startupEditor"] = "none"
-	cfg["explorer.confirmDragAndDrop"] = false
-	cfg["explorer.confirmDelete"] = false
-	cfg["redhat.telemetry.enabled"] = true
-	cfg["editor.renderWhitespace"] = "all"
-
-	// YAML-specific editor settings
-	cfg["[yaml]"] = map[string]interface{}{
-		"editor.insertSpaces":      true,
-		"editor.tabSize":           2,
-		"editor.detectIndentation": false,
+// update merges the desired settings into the settings.json at path,
+// creating it if needed, and reports whether the file changed
+func update(path string, desired *desiredSettings) (bool, error) {
+	// Read existing settings.json if present
+	cfg := make(map[string]interface{})
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("failed to read settings.json: %w", err)
+	}
+	// settings.json may have comments and trailing commas
+	if err := jsonc.Unmarshal(data, &cfg); err != nil {
+		return false, fmt.Errorf("failed to parse existing JSON: %w", err)
 	}
+	if cfg == nil {
+		cfg = make(map[string]interface{}) // null
+	}
+
+	desired.apply(cfg)
 
-	// Marshal JSON with indentation
-	out, err := json.MarshalIndent(cfg, "", "    ")
+	// Edit only what changed, keeping comments and key order
+	out, err := jsonc.Patch(data, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to marshal merged JSON: %v\n", err)
-		os.Exit(1)
+		return false, fmt.Errorf("failed to update JSON: %w", err)
+	}
+	if data != nil && bytes.Equal(out, data) {
+		return false, nil
+	}
+
+	// Backup original if it existed; without one the file is left as it is
+	if data != nil {
+		if err := backup(path, data); err != nil {
+			return false, fmt.Errorf("failed to backup original settings.json, left it unchanged: %w", err)
+		}
 	}
 
 	// Ensure directory exists
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to create directory %q: %v\n", dir, err)
-		os.Exit(1)
+		return false, fmt.Errorf("failed to create directory %q: %w", dir, err)
 	}
 
 	// Atomic write: temp file -> rename
 	tmp := path + ".tmp"
 	if err := os.WriteFile(tmp, out, 0o644); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to write temp settings.json: %v\n", err)
-		os.Exit(1)
+		return false, fmt.Errorf("failed to write temp settings.json: %w", err)
 	}
 	if err := os.Rename(tmp, path); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to overwrite settings.json: %v\n", err)
-		os.Exit(1)
-	}
-
-	fmt.Println("✅ VS Code settings.json updated successfully.")
-}
-
-func settingsPath() (string, error) {
-	appdata := os.Getenv("APPDATA")
-	if appdata == "" {
-		return "", errors.New("APPDATA environment variable not set")
+		return false, fmt.Errorf("failed to overwrite settings.json: %w", err)
 	}
-	return filepath.Join(appdata, "Code", "User", "settings.json"), nil
+	return true, nil
 }
 
 func backup(origPath string, data []byte) error {
@@ -119,12 +140,3 @@ func backup(origPath string, data []byte) error {
 	bakPath := filepath.Join(dir, bakName)
 	return os.WriteFile(bakPath, data, 0o644)
 }
-
-func getResolvedDesktopPath() (string, error) {
-	userProfile := os.Getenv("USERPROFILE")
-	if userProfile == "" {
-		return "", errors.New("USERPROFILE environment variable not set")
-	}
-	desktop := filepath.Join(userProfile, "Desktop")
-	return desktop, nil
-}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"jsonc"
+)
+
+// One settings.json to update
+type target struct {
+	Label    string // for the report
+	Settings string
+}
+
+// VS Code builds and the folder under APPDATA each keeps its settings in,
+// by --variant name
+var variants = []struct{ name, dir string }{
+	{"code", "Code"},
+	{"insiders", "Code - Insiders"},
+	{"codium", "VSCodium"},
+}
+
+// userTargets returns the user settings.json of the variants --variant
+// names. "all" means every variant that has run on this machine, or Code if
+// none has.
+func userTargets(variant string) ([]target, error) {
+	appdata := os.Getenv("APPDATA")
+	if appdata == "" {
+		return nil, errors.New("APPDATA environment variable not set")
+	}
+
+	var targets []target
+	for _, name := range strings.Split(strings.ToLower(variant), ",") {
+		name = strings.TrimSpace(name)
+		known := false
+		for _, v := range variants {
+			dir := filepath.Join(appdata, v.dir, "User")
+			switch {
+			case name == "all":
+				known = true
+				if _, err := os.Stat(filepath.Dir(dir)); err == nil {
+					targets = append(targets, target{Label: v.name, Settings: filepath.Join(dir, "settings.json")})
+				}
+			case name == v.name:
+				known = true
+				targets = append(targets, target{Label: v.name, Settings: filepath.Join(dir, "settings.json")})
+			}
+		}
+		if !known {
+			names := make([]string, len(variants))
+			for i, v := range variants {
+				names[i] = v.name
+			}
+			return nil, fmt.Errorf("unknown --variant %q (expected all or %s)", name, strings.Join(names, ", "))
+		}
+		if name == "all" && len(targets) == 0 {
+			targets = append(targets, target{Label: "code", Settings: filepath.Join(appdata, "Code", "User", "settings.json")})
+		}
+	}
+	return targets, nil
+}
+
+// A VS Code profile, as globalStorage/storage.json lists it
+type userDataProfile struct {
+	Location string `json:"location"` // folder under User/profiles
+	Name     string `json:"name"`
+}
+
+// profileTargets returns the settings.json of each profile of user (a user
+// settings.json) that names picks: names or ids, comma-separated, or all
+func profileTargets(user target, names string) ([]target, error) {
+	dir := filepath.Dir(user.Settings)
+	data, err := os.ReadFile(filepath.Join(dir, "globalStorage", "storage.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var storage struct {
+		Profiles []userDataProfile `json:"userDataProfiles"`
+	}
+	if err := jsonc.Unmarshal(data, &storage); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "globalStorage", "storage.json"), err)
+	}
+
+	var targets []target
+	for _, p := range storage.Profiles {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if strings.EqualFold(name, "all") || strings.EqualFold(name, p.Name) || name == p.Location {
+				targets = append(targets, target{
+					Label:    fmt.Sprintf("%s profile %q", user.Label, p.Name),
+					Settings: filepath.Join(dir, "profiles", p.Location, "settings.json"),
+				})
+				break
+			}
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"jsonc"
+)
+
+// The desired state shipped with the tool; --config replaces it
+//
+//go:embed terminal.yaml
+var builtinTerminal []byte
+
+// What settings.json should say, as described in terminal.yaml
+type desiredState struct {
+	DefaultProfile string                   `yaml:"default profile"`
+	Defaults       map[string]interface{}   `yaml:"defaults"`
+	Font           map[string]interface{}   `yaml:"font"`
+	Profiles       []map[string]interface{} `yaml:"profiles"`
+	HiddenProfiles []string                 `yaml:"hidden profiles"`
+	ColorSchemes   []map[string]interface{} `yaml:"color schemes"`
+	Actions        []map[string]interface{} `yaml:"actions"`
+	Settings       map[string]interface{}   `yaml:"settings"`
+	AutoProfiles   struct {
+		Enabled bool     `yaml:"enabled"`
+		Skip    []string `yaml:"skip"`
+	} `yaml:"auto profiles"`
+}
+
+// readDesired reads the desired state from path, or the built-in one if path is empty
+func readDesired(path string) (*desiredState, error) {
+	var d desiredState
+	if err := jsonc.LoadYAML(path, builtinTerminal, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// apply merges the desired state into cfg, the parsed settings.json, with
+// profiles for the shells found on this machine
+func (d *desiredState) apply(cfg map[string]interface{}, shells []shell) error {
+	// 1. Other top-level settings
+	for key, value := range d.Settings {
+		if value == nil {
+			delete(cfg, key)
+			continue
+		}
+		cfg[key] = jsonc.Merge(cfg[key], value, nil)
+	}
+
+	// 2. Locate profiles object
+	if _, ok := cfg["profiles"]; !ok {
+		cfg["profiles"] = make(map[string]interface{})
+	}
+	profiles, ok := cfg["profiles"].(map[string]interface{})
+	if !ok {
+		return errors.New(`"profiles" is not an object`)
+	}
+
+	// 3. Merge profiles.defaults
+	defaults := jsonc.Merge(profiles["defaults"], d.Defaults, nil)
+	if len(d.Font) > 0 {
+		defaults = jsonc.Merge(defaults, map[string]interface{}{"font": d.Font}, nil)
+	}
+	profiles["defaults"] = defaults
+
+	// 4. Listed profiles first, in order, then the rest as they were
+	list, err := asList(profiles, "list")
+	if err != nil {
+		return fmt.Errorf("profiles.%v", err)
+	}
+	var ordered []interface{}
+	for _, want := range d.Profiles {
+		i, err := findEntry(list, want, []string{"guid", "name"})
+		if err != nil {
+			return fmt.Errorf("profiles: %v", err)
+		}
+		if i < 0 {
+			if want["guid"] == nil {
+				return fmt.Errorf("profile %v is not in settings.json yet and needs a guid", want["name"])
+			}
+			ordered = append(ordered, jsonc.Merge(nil, want, nil))
+			continue
+		}
+		ordered = append(ordered, jsonc.Merge(list[i], want, nil))
+		list = append(list[:i:i], list[i+1:]...)
+	}
+	list = append(ordered, list...)
+
+	// Then the shells that have no profile yet
+	if d.AutoProfiles.Enabled {
+		for _, sh := range shells {
+			skip := false
+			for _, name := range d.AutoProfiles.Skip {
+				skip = skip || strings.EqualFold(name, sh.Name)
+			}
+			if !skip && !hasProfile(list, sh) {
+				list = append(list, sh.profile())
+			}
+		}
+	}
+	profiles["list"] = list
+
+	// 5. Hidden profiles
+	for _, name := range d.HiddenProfiles {
+		i, _ := findEntry(list, map[string]interface{}{"guid": name}, []string{"guid"})
+		if i < 0 {
+			i, _ = findEntry(list, map[string]interface{}{"name": name}, []string{"name"})
+		}
+		if i < 0 {
+			fmt.Fprintf(os.Stderr, "warning: hidden profile %q is not in settings.json\n", name)
+			continue
+		}
+		list[i].(map[string]interface{})["hidden"] = true
+	}
+
+	// 6. Color schemes and actions
+	schemes, err := asList(cfg, "schemes")
+	if err == nil {
+		schemes, err = mergeList(schemes, d.ColorSchemes, "name")
+	}
+	if err != nil {
+		return fmt.Errorf("color schemes: %v", err)
+	}
+	if len(schemes) > 0 {
+		cfg["schemes"] = schemes
+	}
+	actions, err := asList(cfg, "actions")
+	if err == nil {
+		actions, err = mergeList(actions, d.Actions, "keys", "id")
+	}
+	if err != nil {
+		return fmt.Errorf("actions: %v", err)
+	}
+	if len(actions) > 0 {
+		cfg["actions"] = actions
+	}
+
+	// 7. Default profile, by guid
+	if d.DefaultProfile != "" {
+		i, _ := findEntry(list, map[string]interface{}{"guid": d.DefaultProfile}, []string{"guid"})
+		if i < 0 {
+			i, _ = findEntry(list, map[string]interface{}{"name": d.DefaultProfile}, []string{"name"})
+		}
+		if i < 0 {
+			return fmt.Errorf("default profile %q is not in profiles.list", d.DefaultProfile)
+		}
+		guid, _ := list[i].(map[string]interface{})["guid"].(string)
+		if strings.TrimSpace(guid) == "" {
+			return fmt.Errorf("default profile %q has no guid", d.DefaultProfile)
+		}
+		cfg["defaultProfile"] = guid
+	}
+	return nil
+}
@@ -2,89 +2,125 @@
 
 // To open Windows Terminal settings manually, run:
 // code "$env:LOCALAPPDATA\Packages\Microsoft.WindowsTerminal_8wekyb3d8bbwe\LocalState\settings.json"
+// (Preview and Canary use WindowsTerminalPreview_ and WindowsTerminalCanary_;
+// unpackaged installs use "$env:LOCALAPPDATA\Microsoft\Windows Terminal")
 
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
-)
 
-// GUID constants
-const (
-	ps7GUID   = "{574e775e-4f2a-5b96-ac1e-a2962a402336}"
-	winPSGUID = "{61c54bbd-c2c6-5271-96e7-009a87ff44bf}"
-	cmdGUID   = "{0caa0dad-35be-5f56-a8ff-afceeeaa6101}"
-	azureGUID = "{b453ae62-4e3d-5e58-b989-0a998ec441b8}"
+	"jsonc"
 )
 
 func main() {
-	path, err := settingsPath()
+	configPath := flag.String("config", "", "Desired terminal settings (default: the built-in terminal.yaml)")
+	settingsFile := flag.String("settings", "", "settings.json to update instead of the installed terminals'")
+	target := flag.String("target", "all", "Installs to update: all, or any of stable, preview, canary, unpackaged, portable (comma-separated)")
+	portable := flag.String("portable", "", "Folders of portable installs to look in, besides the one on PATH (comma-separated)")
+	flag.Parse()
+
+	desired, err := readDesired(*configPath)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "failed to read desired settings: %v\n", err)
+		os.Exit(1)
+	}
+
+	terminals := []terminal{{Target: "settings", Settings: *settingsFile}}
+	if *settingsFile == "" {
+		var dirs []string
+		if *portable != "" {
+			dirs = strings.Split(*portable, ",")
+		}
+		found, err := findTerminals(dirs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if terminals, err = selectTerminals(found, *target); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(terminals) == 0 {
+			fmt.Fprintf(os.Stderr, "no Windows Terminal install found for --target %s\n", *target)
+			os.Exit(1)
+		}
+	}
+
+	var shells []shell
+	if desired.AutoProfiles.Enabled {
+		shells = detectShells()
+	}
+
+	// Report on every install, then fail if any of them did
+	failed := false
+	for _, t := range terminals {
+		changed, err := update(t.Settings, desired, shells)
+		switch {
+		case errors.Is(err, fs.ErrNotExist) && *settingsFile == "":
+			// Found install that has not written its settings yet
+			fmt.Printf("skipped   %-10s %s (no settings.json yet; start the terminal once)\n", t.Target, t.Settings)
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "failed    %-10s %s: %v\n", t.Target, t.Settings, err)
+			failed = true
+		case changed:
+			fmt.Printf("updated   %-10s %s (backup created)\n", t.Target, t.Settings)
+		default:
+			fmt.Printf("unchanged %-10s %s\n", t.Target, t.Settings)
+		}
+	}
+	if failed {
 		os.Exit(1)
 	}
+}
+
+// update merges the desired state into the settings.json at path, backing up
+// the original first, and reports whether the file changed
+func update(path string, desired *desiredState, shells []shell) (bool, error) {
 	// Read original
 	data, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to read settings.json: %v\n", err)
-		os.Exit(1)
+		return false, err
 	}
-	// Backup
-	if err := backup(path, data); err != nil {
-		fmt.Fprintf(os.Stderr, "warning: backup failed: %v\n", err)
-		// continue anyway
+	// Unmarshal; settings.json may have comments and trailing commas
+	cfg := make(map[string]interface{})
+	if err := jsonc.Unmarshal(data, &cfg); err != nil {
+		return false, fmt.Errorf("failed to parse JSON: %w", err)
 	}
-	// Unmarshal
-	var cfg map[string]interface{}
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to parse JSON: %v\n", err)
-		os.Exit(1)
+	// Merge in the desired state
+	if err := desired.apply(cfg, shells); err != nil {
+		return false, fmt.Errorf("merge error: %w", err)
 	}
-	// Transform
-	if err := transform(cfg); err != nil {
-		fmt.Fprintf(os.Stderr, "transformation error: %v\n", err)
-		os.Exit(1)
-	}
-	// Marshal with indentation
-	out, err := json.MarshalIndent(cfg, "", "    ")
+	// Edit only what changed, keeping comments and key order
+	out, err := jsonc.Patch(data, cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to marshal JSON: %v\n", err)
-		os.Exit(1)
+		return false, fmt.Errorf("failed to update JSON: %w", err)
+	}
+	if bytes.Equal(out, data) {
+		return false, nil
+	}
+	// Backup; without one the file is left as it is
+	if err := backup(path, data); err != nil {
+		return false, fmt.Errorf("backup failed, settings.json left unchanged: %w", err)
 	}
 	// Write back
 	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, out, 0o644); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to write temp file: %v\n", err)
-		os.Exit(1)
+		return false, fmt.Errorf("failed to write temp file: %w", err)
 	}
 	// Replace original
 	if err := os.Rename(tmpPath, path); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to overwrite settings.json: %v\n", err)
-		os.Exit(1)
+		return false, fmt.Errorf("failed to overwrite settings.json: %w", err)
 	}
-	fmt.Println("settings.json updated successfully (backup created).")
-}
-
-// settingsPath builds the path to settings.json using LOCALAPPDATA.
-func settingsPath() (string, error) {
-	local := os.Getenv("LOCALAPPDATA")
-	if local == "" {
-		return "", errors.New("LOCALAPPDATA not set")
-	}
-	// Windows Terminal package path
-	rel := filepath.Join(
-		"Packages",
-		"Microsoft.WindowsTerminal_8wekyb3d8bbwe",
-		"LocalState",
-		"settings.json",
-	)
-	full := filepath.Join(local, rel)
-	return full, nil
+	return true, nil
 }
 
 // backup writes the original data to settings.json.bak.TIMESTAMP
@@ -96,96 +132,3 @@ func backup(origPath string, data []byte) error {
 	bakPath := filepath.Join(dir, bakName)
 	return os.WriteFile(bakPath, data, 0o644)
 }
-
-// transform applies the JSON modifications in-place on cfg.
-func transform(cfg map[string]interface{}) error {
-	// 1. Set defaultProfile
-	cfg["defaultProfile"] = ps7GUID
-
-	// 2. Locate profiles object
-	profilesRaw, ok := cfg["profiles"]
-	if !ok {
-		return errors.New(`missing "profiles" object`)
-	}
-	profiles, ok := profilesRaw.(map[string]interface{})
-	if !ok {
-		return errors.New(`"profiles" is not an object`)
-	}
-	// 3. Set profiles.defaults
-	profiles["defaults"] = map[string]interface{}{
-		"elevate":     true,
-		"historySize": 1000000000,
-	}
-	// 4. Rebuild profiles.list
-	listRaw, ok := profiles["list"]
-	if !ok {
-		return errors.New(`missing "profiles.list"`)
-	}
-	listSlice, ok := listRaw.([]interface{})
-	if !ok {
-		return errors.New(`"profiles.list" is not an array`)
-	}
-	// Index existing entries by GUID
-	entries := make(map[string]map[string]interface{})
-	for _, item := range listSlice {
-		if m, ok := item.(map[string]interface{}); ok {
-			if g, ok := m["guid"].(string); ok {
-				entries[g] = m
-			}
-		}
-	}
-	// Build new list in order: PS7, Windows PowerShell, Command Prompt, Azure Cloud Shell
-	var newList []interface{}
-
-	// helper to prepare each entry
-	prep := func(guid string) map[string]interface{} {
-		if e, exists := entries[guid]; exists {
-			return e
-		}
-		return make(map[string]interface{})
-	}
-
-	// 4a. PowerShell 7
-	ePS7 := prep(ps7GUID)
-	ePS7["guid"] = ps7GUID
-	ePS7["name"] = "PowerShell 7"
-	ePS7["hidden"] = false
-	ePS7["source"] = "Windows.Terminal.PowershellCore"
-	delete(ePS7, "commandline")
-	newList = append(newList, ePS7)
-
-	// 4b. Windows PowerShell -> rename to PowerShell 5
-	eWin := prep(winPSGUID)
-	eWin["guid"] = winPSGUID
-	eWin["name"] = "PowerShell 5"
-	eWin["hidden"] = false
-	// ensure commandline exists; if absent, set default
-	if _, ok := eWin["commandline"]; !ok {
-		eWin["commandline"] = "%SystemRoot%\\System32\\WindowsPowerShell\\v1.0\\powershell.exe"
-	}
-	delete(eWin, "source")
-	newList = append(newList, eWin)
-
-	// 4c. Command Prompt
-	eCmd := prep(cmdGUID)
-	eCmd["guid"] = cmdGUID
-	eCmd["name"] = "Command Prompt"
-	eCmd["hidden"] = false
-	if _, ok := eCmd["commandline"]; !ok {
-		eCmd["commandline"] = "%SystemRoot%\\System32\\cmd.exe"
-	}
-	delete(eCmd, "source")
-	newList = append(newList, eCmd)
-
-	// 4d. Azure Cloud Shell
-	eAz := prep(azureGUID)
-	eAz["guid"] = azureGUID
-	eAz["name"] = "Azure Cloud Shell"
-	eAz["hidden"] = false
-	eAz["source"] = "Windows.Terminal.Azure"
-	delete(eAz, "commandline")
-	newList = append(newList, eAz)
-
-	profiles["list"] = newList
-	return nil
-}
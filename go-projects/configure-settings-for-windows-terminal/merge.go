@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"jsonc"
+)
+
+// findEntry returns the index of the object in list that patch stands for, or
+// -1. The first of fields that patch has decides, compared case-insensitively.
+func findEntry(list []interface{}, patch map[string]interface{}, fields []string) (int, error) {
+	for _, field := range fields {
+		want, ok := patch[field]
+		if !ok || want == nil {
+			continue
+		}
+		for i, item := range list {
+			if entry, ok := item.(map[string]interface{}); ok && entry[field] != nil &&
+				strings.EqualFold(fmt.Sprint(entry[field]), fmt.Sprint(want)) {
+				return i, nil
+			}
+		}
+		return -1, nil
+	}
+	return -1, fmt.Errorf("entry %v has none of: %s", patch, strings.Join(fields, ", "))
+}
+
+// asList returns the array at key in obj, or an empty one if it is missing
+func asList(obj map[string]interface{}, key string) ([]interface{}, error) {
+	raw, ok := obj[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%q is not an array", key)
+	}
+	return list, nil
+}
+
+// mergeList merges each patch into the entry of list it matches by fields
+// and appends the ones that match nothing. Entries keep their order.
+func mergeList(list []interface{}, patches []map[string]interface{}, fields ...string) ([]interface{}, error) {
+	for _, patch := range patches {
+		i, err := findEntry(list, patch, fields)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			list = append(list, jsonc.Merge(nil, patch, nil))
+			continue
+		}
+		list[i] = jsonc.Merge(list[i], patch, nil)
+	}
+	return list, nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+
+	"jsonc"
+)
+
+// The namespace Windows Terminal makes profile GUIDs in
+var profileNamespace = [16]byte{0x2b, 0xde, 0x4a, 0x90, 0xd0, 0x5f, 0x40, 0x1c, 0x94, 0x92, 0xe4, 0x08, 0x84, 0xea, 0xd1, 0xd8}
+
+// profileGUID is the GUID Windows Terminal gives a profile of this name: a
+// version 5 UUID over the name in UTF-16LE. "Windows PowerShell" gives
+// {61c54bbd-c2c6-5271-96e7-009a87ff44bf}, and a WSL distribution's profile
+// gets the same GUID here as from Windows Terminal's own generator.
+func profileGUID(name string) string {
+	h := sha1.New()
+	h.Write(profileNamespace[:])
+	for _, r := range utf16.Encode([]rune(name)) {
+		h.Write([]byte{byte(r), byte(r >> 8)})
+	}
+	u := h.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50 // version 5
+	u[8] = u[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("{%x-%x-%x-%x-%x}", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// A shell found on this machine
+type shell struct {
+	Name              string
+	GUID              string // Windows Terminal's own guid for it; profileGUID(Name) if empty
+	Commandline       string
+	Icon              string
+	StartingDirectory string
+	Source            string // the dynamic profile Windows Terminal makes for it, if any
+}
+
+// The guid of the shell's profile. Windows Terminal's built-in profiles are
+// named after the shell, not the way they show up ("cmd" for Command
+// Prompt), so those have their guids fixed.
+func (s shell) guid() string {
+	if s.GUID != "" {
+		return s.GUID
+	}
+	return profileGUID(s.Name)
+}
+
+func (s shell) profile() map[string]interface{} {
+	p := map[string]interface{}{
+		"guid":              s.guid(),
+		"name":              s.Name,
+		"commandline":       s.Commandline,
+		"startingDirectory": s.StartingDirectory,
+		"hidden":            false,
+	}
+	if s.Icon != "" {
+		p["icon"] = s.Icon
+	}
+	return p
+}
+
+// detectShells looks for the shells the install scripts put on a machine
+func detectShells() []shell {
+	var shells []shell
+	home := "%USERPROFILE%"
+	programFiles := os.Getenv("ProgramFiles")
+	if programFiles == "" {
+		programFiles = `C:\Program Files`
+	}
+
+	// PowerShell 7
+	pwsh, err := exec.LookPath("pwsh.exe")
+	if err != nil {
+		pwsh = filepath.Join(programFiles, "PowerShell", "7", "pwsh.exe")
+	}
+	if isFile(pwsh) {
+		shells = append(shells, shell{
+			Name:              "PowerShell 7",
+			GUID:              "{574e775e-4f2a-5b96-ac1e-a2962a402336}", // "PowerShell Core"
+			Commandline:       quote(pwsh),
+			Icon:              "ms-appx:///ProfileIcons/pwsh.png",
+			StartingDirectory: home,
+			Source:            "Windows.Terminal.PowershellCore",
+		})
+	}
+
+	// Windows PowerShell and cmd come with Windows
+	shells = append(shells,
+		shell{
+			Name:              "Windows PowerShell",
+			GUID:              "{61c54bbd-c2c6-5271-96e7-009a87ff44bf}",
+			Commandline:       `%SystemRoot%\System32\WindowsPowerShell\v1.0\powershell.exe`,
+			Icon:              "ms-appx:///ProfileIcons/{61c54bbd-c2c6-5271-96e7-009a87ff44bf}.png",
+			StartingDirectory: home,
+		},
+		shell{
+			Name:              "Command Prompt",
+			GUID:              "{0caa0dad-35be-5f56-a8ff-afceeeaa6101}", // "cmd"
+			Commandline:       `%SystemRoot%\System32\cmd.exe`,
+			Icon:              "ms-appx:///ProfileIcons/{0caa0dad-35be-5f56-a8ff-afceeeaa6101}.png",
+			StartingDirectory: home,
+		})
+
+	// WSL distributions
+	for _, distro := range wslDistros() {
+		shells = append(shells, shell{
+			Name:              distro,
+			Commandline:       "wsl.exe -d " + distro,
+			Icon:              "ms-appx:///ProfileIcons/{9acb9455-ca41-5af7-950f-6bca1bc9722f}.png",
+			StartingDirectory: "~",
+		})
+	}
+
+	// Miniconda's activated prompt
+	for _, root := range []string{
+		filepath.Join(os.Getenv("USERPROFILE"), "miniconda3"),
+		filepath.Join(os.Getenv("LOCALAPPDATA"), "miniconda3"),
+		filepath.Join(os.Getenv("ProgramData"), "miniconda3"),
+	} {
+		activate := filepath.Join(root, "Scripts", "activate.bat")
+		if !isFile(activate) {
+			continue
+		}
+		s := shell{
+			Name:              "Miniconda",
+			Commandline:       fmt.Sprintf(`%%SystemRoot%%\System32\cmd.exe /K %s %s`, quote(activate), quote(root)),
+			StartingDirectory: home,
+		}
+		if icon := filepath.Join(root, "Menu", "anaconda_console.ico"); isFile(icon) {
+			s.Icon = icon
+		}
+		shells = append(shells, s)
+		break
+	}
+
+	// Git Bash
+	if bash := filepath.Join(programFiles, "Git", "bin", "bash.exe"); isFile(bash) {
+		s := shell{
+			Name:              "Git Bash",
+			Commandline:       quote(bash) + " -i -l",
+			StartingDirectory: home,
+		}
+		if icon := filepath.Join(programFiles, "Git", "mingw64", "share", "git", "git-for-windows.ico"); isFile(icon) {
+			s.Icon = icon
+		}
+		shells = append(shells, s)
+	}
+
+	// This repository's shell REPL, built next to this tool
+	if exe, err := os.Executable(); err == nil {
+		if repl := filepath.Join(filepath.Dir(exe), "..", "shell", "shell.exe"); isFile(repl) {
+			shells = append(shells, shell{
+				Name:              "Shell REPL",
+				Commandline:       quote(filepath.Clean(repl)),
+				StartingDirectory: home,
+			})
+		}
+	}
+	return shells
+}
+
+// The distributions wsl.exe knows about; none if WSL is not installed
+func wslDistros() []string {
+	out, err := exec.Command("wsl.exe", "--list", "--quiet").Output()
+	if err != nil {
+		return nil
+	}
+	// wsl.exe writes UTF-16LE
+	if len(out) >= 2 && out[1] == 0 {
+		units := make([]uint16, len(out)/2)
+		for i := range units {
+			units[i] = uint16(out[2*i]) | uint16(out[2*i+1])<<8
+		}
+		out = []byte(string(utf16.Decode(units)))
+	}
+	var distros []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimSpace(strings.TrimPrefix(line, "\ufeff")); line != "" {
+			distros = append(distros, line)
+		}
+	}
+	return distros
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+func quote(path string) string {
+	if strings.ContainsAny(path, " \t") {
+		return `"` + path + `"`
+	}
+	return path
+}
+
+// A commandline as it runs: %VARIABLES% expanded, case and spacing ignored
+func normalizeCommandline(commandline string) string {
+	expanded := jsonc.ExpandEnv(commandline).(string)
+	return strings.ToLower(strings.Join(strings.Fields(expanded), " "))
+}
+
+// Whether list already has a profile for s: the same guid, name or
+// commandline, or the dynamic profile Windows Terminal makes for it
+func hasProfile(list []interface{}, s shell) bool {
+	guid := s.guid()
+	for _, item := range list {
+		p, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		text := func(key string) string {
+			value, _ := p[key].(string)
+			return value
+		}
+		switch {
+		case strings.EqualFold(text("guid"), guid),
+			strings.EqualFold(text("name"), s.Name),
+			text("commandline") != "" && normalizeCommandline(text("commandline")) == normalizeCommandline(s.Commandline),
+			s.Source != "" && text("source") == s.Source:
+			return true
+		}
+	}
+	return false
+}
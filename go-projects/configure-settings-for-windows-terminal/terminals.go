@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// One Windows Terminal install and the settings.json it reads
+type terminal struct {
+	Target   string // what --target calls it
+	Settings string
+}
+
+// Store packages, by --target name
+var packages = []struct{ target, family string }{
+	{"stable", "Microsoft.WindowsTerminal_8wekyb3d8bbwe"},
+	{"preview", "Microsoft.WindowsTerminalPreview_8wekyb3d8bbwe"},
+	{"canary", "Microsoft.WindowsTerminalCanary_8wekyb3d8bbwe"},
+}
+
+// What --target accepts besides all
+var targets = []string{"stable", "preview", "canary", "unpackaged", "portable"}
+
+// findTerminals lists the installs whose settings folder exists. A portable
+// install keeps a .portable file next to WindowsTerminal.exe and its settings
+// in a settings folder beside it; the folders in portableDirs are checked,
+// and so is the one WindowsTerminal.exe is found in on PATH.
+func findTerminals(portableDirs []string) ([]terminal, error) {
+	local := os.Getenv("LOCALAPPDATA")
+	if local == "" {
+		return nil, errors.New("LOCALAPPDATA not set")
+	}
+
+	var found []terminal
+	for _, pkg := range packages {
+		found = appendIfDir(found, pkg.target, filepath.Join(local, "Packages", pkg.family, "LocalState"))
+	}
+	found = appendIfDir(found, "unpackaged", filepath.Join(local, "Microsoft", "Windows Terminal"))
+
+	if exe, err := exec.LookPath("WindowsTerminal.exe"); err == nil {
+		portableDirs = append(portableDirs, filepath.Dir(exe))
+	}
+	seen := make(map[string]bool)
+	for _, dir := range portableDirs {
+		dir = filepath.Clean(strings.TrimSpace(dir))
+		if seen[strings.ToLower(dir)] {
+			continue
+		}
+		seen[strings.ToLower(dir)] = true
+		if _, err := os.Stat(filepath.Join(dir, ".portable")); err == nil {
+			found = appendIfDir(found, "portable", filepath.Join(dir, "settings"))
+		}
+	}
+	return found, nil
+}
+
+func appendIfDir(found []terminal, target, dir string) []terminal {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		found = append(found, terminal{Target: target, Settings: filepath.Join(dir, "settings.json")})
+	}
+	return found
+}
+
+// selectTerminals keeps the installs --target names (comma-separated, or all)
+func selectTerminals(found []terminal, target string) ([]terminal, error) {
+	wanted := make(map[string]bool)
+	for _, name := range strings.Split(strings.ToLower(target), ",") {
+		name = strings.TrimSpace(name)
+		if name == "all" {
+			return found, nil
+		}
+		known := false
+		for _, t := range targets {
+			known = known || t == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown --target %q (expected all or %s)", name, strings.Join(targets, ", "))
+		}
+		wanted[name] = true
+	}
+	var selected []terminal
+	for _, t := range found {
+		if wanted[t.Target] {
+			selected = append(selected, t)
+		}
+	}
+	return selected, nil
+}
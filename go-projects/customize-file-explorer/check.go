@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Whether the registry currently holds every value that setting value writes
+func valueMatches(reg registry, desk desktop, writes []registryWrite) (bool, error) {
+	for _, w := range writes {
+		desired, err := desiredValue(w, desk)
+		if err != nil {
+			return false, err
+		}
+		current, found, err := reg.Get(w.Key, w.Name)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s\\%s: %w", w.Key, w.Name, err)
+		}
+		if !found || current != desired {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Which defined value the registry is in, trying prefer first. Reports false
+// when the registry matches none of them (values missing or set by hand).
+func currentValue(reg registry, desk desktop, def settingDefinition, prefer string) (string, bool, error) {
+	candidates := make([]string, 0, len(def.Values))
+	for value := range def.Values {
+		if value != prefer {
+			candidates = append(candidates, value)
+		}
+	}
+	sort.Strings(candidates)
+	if _, ok := def.Values[prefer]; ok {
+		candidates = append([]string{prefer}, candidates...)
+	}
+
+	for _, value := range candidates {
+		ok, err := valueMatches(reg, desk, def.Values[value])
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			return value, true, nil
+		}
+	}
+
+	// Free-form values: read back the value written as {value} and make sure
+	// everything else it would write is in place too
+	if def.Format == "" {
+		return "", false, nil
+	}
+	for _, w := range def.Other {
+		if w.Data != "{value}" {
+			continue
+		}
+		current, found, err := reg.Get(w.Key, w.Name)
+		if err != nil || !found {
+			return "", false, err
+		}
+		value, ok := valueFormats[def.Format].fromData(current.Data)
+		if !ok {
+			return "", false, nil
+		}
+		writes, err := writesFor(def, value)
+		if err != nil {
+			return "", false, nil
+		}
+		if ok, err := valueMatches(reg, desk, writes); err != nil || !ok {
+			return "", false, err
+		}
+		return value, true, nil
+	}
+	return "", false, nil
+}
+
+// Every registry value a setting can write, keyed by registryPath
+func definitionWrites(def settingDefinition) map[string]registryWrite {
+	all := make(map[string]registryWrite)
+	for _, writes := range def.Values {
+		for _, w := range writes {
+			all[registryPath(w.Key, w.Name)] = w
+		}
+	}
+	for _, w := range def.Other {
+		all[registryPath(w.Key, w.Name)] = w
+	}
+	return all
+}
+
+// What the registry holds for a setting, for reports when it matches no value
+func describeRegistry(reg registry, def settingDefinition) string {
+	var parts []string
+	for _, w := range definitionWrites(def) {
+		current, found, err := reg.Get(w.Key, w.Name)
+		switch {
+		case err != nil:
+			parts = append(parts, fmt.Sprintf("%s unreadable", w.Name))
+		case !found:
+			parts = append(parts, fmt.Sprintf("%s not set", w.Name))
+		default:
+			parts = append(parts, fmt.Sprintf("%s = %q", w.Name, current.Data))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ", ")
+}
+
+// check: compare the machine with configuration_profile without changing anything
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to the config.yaml file (required)")
+	logPath := fs.String("log", "", "Also append the report to this file")
+	settingsPath := fs.String("settings", "", "Setting definitions to use instead of the built-in settings.yaml")
+	profileName := fs.String("profile", "", "Profiles from config.yaml to apply over configuration_profile (comma-separated)")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		fmt.Println("❌ Error: --config is required.")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if logFile := openLog(*logPath); logFile != nil {
+		defer logFile.Close()
+	}
+
+	defs := readDefinitions(*settingsPath)
+	profile := readProfile(*configPath, defs, *profileName)
+	reg, desk := openSystemOrMemory(false)
+
+	matched, drifted, unknown := 0, 0, 0
+	for _, def := range defs {
+		want, exists := profile.Get(def.Key)
+		if !exists {
+			if def.ReplacedBy == "" {
+				log.Printf("⏭️  %-60s not in config.yaml", def.Key)
+			}
+			continue
+		}
+
+		have, ok, err := currentValue(reg, desk, def, want)
+		if err != nil {
+			log.Fatalf("❌ %s: %v", def.Key, err)
+		}
+		switch {
+		case !ok:
+			unknown++
+			log.Printf("❓ unknown %-52s want %s; registry: %s", def.Key, want, describeRegistry(reg, def))
+		case sameValue(def, have, want):
+			matched++
+			log.Printf("✅ match   %-52s %s", def.Key, want)
+		default:
+			drifted++
+			log.Printf("❌ drift   %-52s want %s, found %s", def.Key, want, have)
+		}
+	}
+
+	log.Printf("📊 Compliance: %d match, %d drift, %d unknown", matched, drifted, unknown)
+	if drifted > 0 {
+		os.Exit(1)
+	}
+}
+
+// Find or create the mapping for key under parent
+func childMapping(parent *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(parent.Content); i += 2 {
+		if strings.EqualFold(parent.Content[i].Value, key) && parent.Content[i+1].Kind == yaml.MappingNode {
+			return parent.Content[i+1]
+		}
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, child)
+	return child
+}
+
+// capture: write the machine's current settings out as a config.yaml
+func runCapture(args []string) {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	outputPath := fs.String("output", "", "Where to write the captured config.yaml (default: print it)")
+	settingsPath := fs.String("settings", "", "Setting definitions to use instead of the built-in settings.yaml")
+	fs.Parse(args)
+
+	// Keep stdout clean for the YAML when printing it
+	if *outputPath == "" {
+		log.SetOutput(os.Stderr)
+	}
+
+	defs := readDefinitions(*settingsPath)
+	reg, desk := openSystemOrMemory(false)
+
+	profile := &yaml.Node{Kind: yaml.MappingNode}
+	captured := 0
+	covered := make(map[string]bool) // registry values already described by a captured setting
+	for _, def := range defs {
+		// Older settings that only write what a captured one already does
+		// (custom short date pattern vs. short date pattern) would just repeat it
+		writes := definitionWrites(def)
+		redundant := true
+		for path := range writes {
+			redundant = redundant && covered[path]
+		}
+		if redundant {
+			continue
+		}
+
+		value, ok, err := currentValue(reg, desk, def, "")
+		if err != nil {
+			log.Fatalf("❌ %s: %v", def.Key, err)
+		}
+		if !ok {
+			log.Printf("⚠️  %s left out: registry matches no defined value (%s)", def.Key, describeRegistry(reg, def))
+			continue
+		}
+
+		parts := strings.Split(def.Key, ".")
+		parent := profile
+		for _, part := range parts[:len(parts)-1] {
+			parent = childMapping(parent, part)
+		}
+		parent.Content = append(parent.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: value})
+		captured++
+		for path := range writes {
+			covered[path] = true
+		}
+	}
+
+	host, _ := os.Hostname()
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{
+		Kind:        yaml.MappingNode,
+		HeadComment: fmt.Sprintf("Captured from %s on %s by customize-file-explorer capture", host, time.Now().Format("2006-01-02 15:04:05")),
+		Content:     []*yaml.Node{{Kind: yaml.ScalarNode, Value: "configuration_profile"}, profile},
+	}}}
+	// Two-space indentation, like config.yaml
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		log.Fatalf("❌ Failed to render YAML: %v", err)
+	}
+	out := buf.Bytes()
+
+	if *outputPath == "" {
+		fmt.Print(string(out))
+		return
+	}
+	if existing, err := os.ReadFile(*outputPath); err == nil {
+		backupPath := *outputPath + ".bak"
+		if err := os.WriteFile(backupPath, existing, 0644); err != nil {
+			log.Fatalf("❌ Failed to back up %s: %v", *outputPath, err)
+		}
+		log.Printf("🔁 Existing file backed up: %s → %s", *outputPath, backupPath)
+	}
+	if err := os.WriteFile(*outputPath, out, 0644); err != nil {
+		log.Fatalf("❌ Failed to write %s: %v", *outputPath, err)
+	}
+	log.Printf("✅ Captured %d of %d settings to %s", captured, len(defs), *outputPath)
+}
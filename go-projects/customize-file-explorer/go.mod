@@ -2,4 +2,11 @@ module customize-file-explorer
 
 go 1.24.4
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	config-profile v0.0.0
+	golang.org/x/sys v0.33.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+// Shared config.yaml loader, kept next to the tools that use it
+replace config-profile => ../config-profile
@@ -6,139 +6,169 @@ import (
 	"io"
 	"log"
 	"os"
-	"os/exec"
-	"strings"
 
-	"gopkg.in/yaml.v3"
+	configprofile "config-profile"
 )
 
-type SettingMapping struct {
-	YamlKeyPath string            // e.g. "explorer.dark_mode"
-	ValueMap    map[string]string // maps values like "on" or "true" to PowerShell functions
+// Registry writes during --dry-run go to an overlay, so later reads see them
+// but the real registry is left alone
+type dryRunRegistry struct {
+	registry
+	changes *memoryRegistry
 }
 
-var mappings = []SettingMapping{
-	// Explorer settings
-	{"explorer.dark_mode", map[string]string{"true": "Set-DarkMode", "false": "Set-LightMode"}},
-	{"explorer.search_box", map[string]string{"hidden": "Set-HideSearchBox", "shown": "Set-ShowSearchBox"}},
-	{"explorer.file_extensions", map[string]string{"hidden": "Set-HideFileExtensions", "shown": "Set-ShowFileExtensions"}},
-	{"explorer.hidden_files", map[string]string{"hidden": "Set-HideHiddenFiles", "shown": "Set-ShowHiddenFiles"}},
-	{"explorer.start_menu_alignment", map[string]string{"left": "Set-StartMenuToLeft", "center": "Set-StartMenuToCenter"}},
-
-	// Date/time settings
-	{"date time settings.show seconds in taskbar", map[string]string{"on": "Set-ShowSecondsInTaskbar", "off": "Set-HideSecondsInTaskbar"}},
-	{"date time settings.custom short date pattern", map[string]string{"on": "Set-CustomShortDatePattern", "off": "Reset-ShortDatePattern"}},
-	{"date time settings.custom long date pattern", map[string]string{"on": "Set-CustomLongDatePattern", "off": "Reset-LongDatePattern"}},
-	{"date time settings.custom time pattern", map[string]string{"on": "Set-CustomTimePattern", "off": "Reset-TimePatternToDefault"}},
-	{"date time settings.24 hour time format", map[string]string{"on": "Set-24HourTimeFormat", "off": "Reset-12HourTimeFormat"}},
-	{"date time settings.set first day of the week to monday", map[string]string{"on": "Set-FirstDayOfWeekMonday", "off": "Set-FirstDayOfWeekSunday"}},
+func (d *dryRunRegistry) Get(key, name string) (registryValue, bool, error) {
+	if value, ok, _ := d.changes.Get(key, name); ok {
+		return value, true, nil
+	}
+	return d.registry.Get(key, name)
 }
 
-// Case-insensitive nested lookup
-func getNestedValue(m map[string]interface{}, path string) (interface{}, bool) {
-	parts := strings.Split(path, ".")
-	var current interface{} = m
-
-	for _, part := range parts {
-		if mTyped, ok := current.(map[string]interface{}); ok {
-			lowered := strings.ToLower(part)
-			found := false
-			for key, val := range mTyped {
-				if strings.ToLower(key) == lowered {
-					current = val
-					found = true
-					break
-				}
-			}
-			if !found {
-				return nil, false
-			}
-		} else {
-			return nil, false
-		}
-	}
-	return current, true
+func (d *dryRunRegistry) Set(key, name string, value registryValue) error {
+	return d.changes.Set(key, name, value)
 }
 
-func main() {
-	configPath := flag.String("config", "", "Path to the config.yaml file (required)")
-	modulePath := flag.String("module", "", "Path to the PowerShell module (.psm1) file (required)")
-	logPath := flag.String("log", "", "Path to the log file (required)")
-	flag.Parse()
+func (d *dryRunRegistry) Delete(key, name string) error {
+	return d.changes.Delete(key, name)
+}
 
-	if *configPath == "" || *modulePath == "" || *logPath == "" {
-		fmt.Println("❌ Error: --config, --module, and --log are all required.")
-		flag.Usage()
-		os.Exit(1)
-	}
+type dryRunDesktop struct {
+	desktop
+}
+
+func (dryRunDesktop) Broadcast(area string) error {
+	log.Printf("ℹ️ Dry run: would broadcast %s change", area)
+	return nil
+}
+
+func (dryRunDesktop) RestartExplorer() error {
+	log.Println("ℹ️ Dry run: would restart Explorer")
+	return nil
+}
 
-	logFile, err := os.OpenFile(*logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+// Log to stdout and, if path is set, append to a log file
+func openLog(path string) *os.File {
+	if path == "" {
+		return nil
+	}
+	logFile, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		fmt.Printf("❌ Failed to open log file %s: %v\n", *logPath, err)
+		fmt.Printf("❌ Failed to open log file %s: %v\n", path, err)
 		os.Exit(1)
 	}
-	defer logFile.Close()
 	log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	return logFile
+}
 
-	log.Println("📄 Reading config:", *configPath)
-	content, err := os.ReadFile(*configPath)
+// The built-in definitions, or the ones in path
+func readDefinitions(path string) []settingDefinition {
+	data := builtinSettings
+	if path != "" {
+		log.Println("📄 Reading setting definitions:", path)
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			log.Fatalf("❌ Failed to read setting definitions: %v", err)
+		}
+	}
+	defs, err := loadDefinitions(data)
 	if err != nil {
-		log.Fatalf("❌ Failed to read config file: %v", err)
+		log.Fatalf("❌ Invalid setting definitions: %v", err)
 	}
+	return defs
+}
 
-	var raw map[string]interface{}
-	if err := yaml.Unmarshal(content, &raw); err != nil {
-		log.Fatalf("❌ Failed to parse YAML: %v", err)
+// Read config.yaml with the profiles for this machine (and --profile) merged
+// in, refusing unknown keys and values before anything is applied
+func readProfile(path string, defs []settingDefinition, profiles string) *configprofile.Profile {
+	log.Println("📄 Reading config:", path)
+	profile, err := configprofile.Load(path, profileSchema(defs), configprofile.ThisMachine(profiles))
+	if err != nil {
+		log.Fatalf("❌ Invalid config:\n%v", err)
 	}
-
-	root, ok := raw["configuration_profile"].(map[string]interface{})
-	if !ok {
-		log.Fatalf("❌ 'configuration_profile' not found or invalid")
+	for _, l := range profile.Layers()[1:] {
+		log.Printf("ℹ️ Using %s", l)
 	}
+	return profile
+}
 
-	var psFunctions []string
+// The Windows registry, or with allowMemory an empty in-memory one where there is none
+func openSystemOrMemory(allowMemory bool) (registry, desktop) {
+	reg, desk, err := openSystem()
+	if err == nil {
+		return reg, desk
+	}
+	if !allowMemory {
+		log.Fatalf("❌ %v", err)
+	}
+	log.Printf("⚠️ %v; using an empty registry", err)
+	return newMemoryRegistry(), &memoryDesktop{Locale: map[string]string{
+		"ShortDate": "M/d/yyyy", "LongDate": "dddd, MMMM d, yyyy", "TimeFormat": "h:mm:ss tt",
+		"ShortTime": "h:mm tt", "TimeSeparator": ":", "FirstDayOfWeek": "6",
+	}}
+}
 
-	log.Println("🔧 Translating configuration to PowerShell functions...")
-	for _, mapping := range mappings {
-		if val, exists := getNestedValue(root, mapping.YamlKeyPath); exists {
-			strVal := fmt.Sprintf("%v", val)
-			if psFunc, ok := mapping.ValueMap[strings.ToLower(strVal)]; ok {
-				psFunctions = append(psFunctions, psFunc)
-				log.Printf("✔️  %s = %s → %s", mapping.YamlKeyPath, strVal, psFunc)
-			} else {
-				log.Printf("⚠️  Unknown value: %s = %s", mapping.YamlKeyPath, strVal)
-			}
-		} else {
-			log.Printf("⚠️  Key not found in YAML: %s", mapping.YamlKeyPath)
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "capture":
+			runCapture(os.Args[2:])
+			return
+		case "resolve":
+			runResolve(os.Args[2:])
+			return
 		}
 	}
+	runApply()
+}
 
-	if len(psFunctions) == 0 {
-		log.Println("⚠️ No PowerShell functions matched. Exiting.")
-		return
+func runApply() {
+	configPath := flag.String("config", "", "Path to the config.yaml file (required)")
+	flag.String("module", "", "No longer used: settings are applied directly, not through the PowerShell module")
+	logPath := flag.String("log", "", "Path to the log file (required)")
+	settingsPath := flag.String("settings", "", "Setting definitions to use instead of the built-in settings.yaml")
+	profileName := flag.String("profile", "", "Profiles from config.yaml to apply over configuration_profile (comma-separated)")
+	dryRun := flag.Bool("dry-run", false, "Report what would change without writing the registry")
+	flag.Usage = func() {
+		fmt.Println("Usage:")
+		fmt.Println("  customize-file-explorer --config <config.yaml> --log <file> [--profile <name>] [--dry-run]")
+		fmt.Println("  customize-file-explorer check --config <config.yaml> [--profile <name>]")
+		fmt.Println("  customize-file-explorer capture --output <config.yaml>")
+		fmt.Println("  customize-file-explorer resolve --config <config.yaml> [--profile <name>]")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	psScript := fmt.Sprintf("Import-Module '%s'\n", *modulePath)
-	for _, fn := range psFunctions {
-		psScript += fn + "\n"
+	if *configPath == "" || *logPath == "" {
+		fmt.Println("❌ Error: --config and --log are required.")
+		flag.Usage()
+		os.Exit(1)
 	}
 
-	tempScript := "run.ps1"
-	if err := os.WriteFile(tempScript, []byte(psScript), 0644); err != nil {
-		log.Fatalf("❌ Failed to write temporary PowerShell script: %v", err)
+	if logFile := openLog(*logPath); logFile != nil {
+		defer logFile.Close()
 	}
-	defer os.Remove(tempScript)
 
-	log.Printf("📝 Wrote script: %s", tempScript)
+	defs := readDefinitions(*settingsPath)
+	profile := readProfile(*configPath, defs, *profileName)
 
-	log.Println("🚀 Running PowerShell script...")
-	cmd := exec.Command("powershell", "-ExecutionPolicy", "Bypass", "-File", tempScript)
-	cmd.Stdout = logFile
-	cmd.Stderr = logFile
-	err = cmd.Run()
+	reg, desk := openSystemOrMemory(*dryRun)
+	if *dryRun {
+		reg = &dryRunRegistry{registry: reg, changes: newMemoryRegistry()}
+		desk = dryRunDesktop{desk}
+	}
+
+	log.Println("🔧 Applying settings...")
+	result, err := applySettings(reg, desk, defs, profile)
 	if err != nil {
-		log.Fatalf("❌ PowerShell execution failed: %v", err)
+		log.Fatalf("❌ %v", err)
 	}
 
-	log.Println("✅ PowerShell script executed successfully.")
+	if *dryRun {
+		log.Printf("ℹ️ Dry run: %d value(s) would change, %d already set.", result.Changed, result.Unchanged)
+		return
+	}
+	log.Printf("✅ Settings applied: %d value(s) changed, %d already set.", result.Changed, result.Unchanged)
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// How a free-form config value becomes registry data. toData validates the
+// value and returns the placeholders for the setting's other writes
+// ({value}, {separator}); fromData turns registry data back into a config value.
+type valueFormat struct {
+	toData   func(value string) (map[string]string, error)
+	fromData func(data string) (string, bool)
+}
+
+var valueFormats = map[string]valueFormat{
+	"date": {
+		toData: func(value string) (map[string]string, error) {
+			if err := validatePattern(value, dateTokens, timeTokens); err != nil {
+				return nil, err
+			}
+			return map[string]string{"value": value}, nil
+		},
+		fromData: func(data string) (string, bool) {
+			return data, validatePattern(data, dateTokens, timeTokens) == nil
+		},
+	},
+	"time": {
+		toData: func(value string) (map[string]string, error) {
+			if err := validatePattern(value, timeTokens, dateTokens); err != nil {
+				return nil, err
+			}
+			return map[string]string{"value": value, "separator": timeSeparator(value)}, nil
+		},
+		fromData: func(data string) (string, bool) {
+			return data, validatePattern(data, timeTokens, dateTokens) == nil
+		},
+	},
+	"weekday": {
+		toData: func(value string) (map[string]string, error) {
+			for i, day := range weekdays {
+				if strings.EqualFold(value, day) || (len(value) >= 3 && strings.EqualFold(value, day[:3])) {
+					return map[string]string{"value": strconv.Itoa(i)}, nil
+				}
+			}
+			return nil, fmt.Errorf("'%s' is not a weekday (Monday … Sunday)", value)
+		},
+		fromData: func(data string) (string, bool) {
+			i, err := strconv.Atoi(data)
+			if err != nil || i < 0 || i >= len(weekdays) {
+				return "", false
+			}
+			return weekdays[i], true
+		},
+	},
+}
+
+// In iFirstDayOfWeek order (0 = Monday)
+var weekdays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
+// Longest run allowed for each picture letter, and which run lengths are valid
+var dateTokens = map[rune][]int{
+	'd': {1, 2, 3, 4},
+	'M': {1, 2, 3, 4},
+	'y': {1, 2, 4, 5},
+	'g': {1, 2},
+}
+
+var timeTokens = map[rune][]int{
+	'h': {1, 2},
+	'H': {1, 2},
+	'm': {1, 2},
+	's': {1, 2},
+	't': {1, 2},
+}
+
+// Common mistakes, mostly from other date libraries
+var tokenHints = map[rune]string{
+	'Y': "years are y",
+	'D': "days are d",
+	'S': "seconds are s",
+	'T': "AM/PM is t",
+	'a': "AM/PM is t",
+	'A': "AM/PM is t",
+	'f': "fractions of a second are not supported in Windows locale formats",
+	'F': "fractions of a second are not supported in Windows locale formats",
+	'z': "time zones are not supported in Windows locale formats",
+	'K': "time zones are not supported in Windows locale formats",
+}
+
+// Windows stores at most 80 characters for these values (LOCALE_SSHORTDATE and friends)
+const maxPatternLength = 80
+
+// Check a date or time picture the way the Region control panel would:
+// runs of the picture letters, literal text in single quotes (two quotes for one)
+// and any other non-letter characters as separators. Letters belonging to the
+// other kind (e.g. HH in a date pattern) are rejected.
+func validatePattern(pattern string, allowed, other map[rune][]int) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("pattern is empty")
+	}
+	if n := utf8.RuneCountInString(pattern); n > maxPatternLength {
+		return fmt.Errorf("pattern is %d characters long; Windows allows at most %d", n, maxPatternLength)
+	}
+
+	runes := []rune(pattern)
+	tokens := 0
+	hourStyles := make(map[rune]bool)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+
+		if c == '\'' {
+			end := i + 1
+			for ; end < len(runes); end++ {
+				if runes[end] == '\'' {
+					break
+				}
+			}
+			if end == len(runes) {
+				return fmt.Errorf("quote at position %d is never closed", i+1)
+			}
+			i = end + 1
+			continue
+		}
+
+		run := 1
+		for i+run < len(runes) && runes[i+run] == c {
+			run++
+		}
+
+		if lengths, ok := allowed[c]; ok {
+			valid := false
+			for _, n := range lengths {
+				valid = valid || n == run
+			}
+			if !valid {
+				return fmt.Errorf("'%s' at position %d is not a valid format (use %s)", strings.Repeat(string(c), run), i+1, tokenChoices(c, lengths))
+			}
+			tokens++
+			if c == 'h' || c == 'H' {
+				hourStyles[c] = true
+			}
+		} else if _, ok := other[c]; ok {
+			return fmt.Errorf("'%s' at position %d does not belong in this pattern; quote it ('%s') if it is literal text", strings.Repeat(string(c), run), i+1, strings.Repeat(string(c), run))
+		} else if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			if hint, ok := tokenHints[c]; ok {
+				return fmt.Errorf("'%c' at position %d is not a format letter (%s)", c, i+1, hint)
+			}
+			return fmt.Errorf("'%c' at position %d is not a format letter; put literal text in single quotes", c, i+1)
+		}
+		i += run
+	}
+
+	if tokens == 0 {
+		return fmt.Errorf("pattern contains no date or time fields")
+	}
+	if len(hourStyles) > 1 {
+		return fmt.Errorf("pattern mixes 12-hour (h) and 24-hour (H) hours")
+	}
+	return nil
+}
+
+func tokenChoices(c rune, lengths []int) string {
+	var choices []string
+	for _, n := range lengths {
+		choices = append(choices, strings.Repeat(string(c), n))
+	}
+	return strings.Join(choices, ", ")
+}
+
+// The separator between hours and minutes, which Windows keeps in sTime
+func timeSeparator(pattern string) string {
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != 'h' && runes[i] != 'H' {
+			continue
+		}
+		j := i
+		for j < len(runes) && runes[j] == runes[i] {
+			j++
+		}
+		var sep []rune
+		for ; j < len(runes) && runes[j] != 'm'; j++ {
+			if runes[j] != '\'' {
+				sep = append(sep, runes[j])
+			}
+		}
+		if j < len(runes) && len(sep) > 0 {
+			return string(sep)
+		}
+		break
+	}
+	return ":"
+}
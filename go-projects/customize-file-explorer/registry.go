@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Registry value types the settings use
+type valueType string
+
+const (
+	typeDWord  valueType = "dword"
+	typeString valueType = "string"
+)
+
+type registryValue struct {
+	Type valueType
+	Data string // DWORDs are kept as decimal text
+}
+
+// The part of the registry the settings engine needs. Keys are written as
+// HKCU\Software\... with the hive first.
+type registry interface {
+	// Get reports false if the key or value does not exist
+	Get(key, name string) (registryValue, bool, error)
+	// Set creates the key if needed
+	Set(key, name string, value registryValue) error
+	// Delete removes a value; a value that does not exist is not an error
+	Delete(key, name string) error
+}
+
+// What else applying settings may need from the desktop session
+type desktop interface {
+	// LocaleDefault returns the user locale's value for name without user overrides
+	LocaleDefault(name string) (string, error)
+	// Broadcast sends WM_SETTINGCHANGE for area (e.g. "intl")
+	Broadcast(area string) error
+	RestartExplorer() error
+}
+
+// In-memory registry, for dry runs and for exercising the engine without Windows
+type memoryRegistry struct {
+	values map[string]registryValue
+}
+
+func newMemoryRegistry() *memoryRegistry {
+	return &memoryRegistry{values: make(map[string]registryValue)}
+}
+
+// Key and value names are case-insensitive, as in the real registry
+func registryPath(key, name string) string {
+	return strings.ToLower(strings.TrimRight(key, `\`) + `\` + name)
+}
+
+func (m *memoryRegistry) Get(key, name string) (registryValue, bool, error) {
+	value, ok := m.values[registryPath(key, name)]
+	return value, ok, nil
+}
+
+func (m *memoryRegistry) Set(key, name string, value registryValue) error {
+	m.values[registryPath(key, name)] = value
+	return nil
+}
+
+func (m *memoryRegistry) Delete(key, name string) error {
+	delete(m.values, registryPath(key, name))
+	return nil
+}
+
+// Desktop that records what would have happened
+type memoryDesktop struct {
+	Locale     map[string]string
+	Broadcasts []string
+	Restarts   int
+}
+
+func (m *memoryDesktop) LocaleDefault(name string) (string, error) {
+	if value, ok := m.Locale[name]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("no locale default for %s", name)
+}
+
+func (m *memoryDesktop) Broadcast(area string) error {
+	m.Broadcasts = append(m.Broadcasts, area)
+	return nil
+}
+
+func (m *memoryDesktop) RestartExplorer() error {
+	m.Restarts++
+	return nil
+}
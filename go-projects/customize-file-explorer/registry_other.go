@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// The settings live in the Windows registry; elsewhere only --dry-run works, against an empty in-memory registry
+func openSystem() (registry, desktop, error) {
+	return nil, nil, errors.New("Explorer settings can only be applied on Windows")
+}
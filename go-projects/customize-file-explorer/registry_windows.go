@@ -0,0 +1,163 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	winreg "golang.org/x/sys/windows/registry"
+)
+
+type windowsRegistry struct{}
+
+type windowsDesktop struct{}
+
+func openSystem() (registry, desktop, error) {
+	return windowsRegistry{}, windowsDesktop{}, nil
+}
+
+func openKey(path string, access uint32, create bool) (winreg.Key, error) {
+	hive, sub, _ := strings.Cut(path, `\`)
+	var root winreg.Key
+	switch strings.ToUpper(hive) {
+	case "HKCU":
+		root = winreg.CURRENT_USER
+	case "HKLM":
+		root = winreg.LOCAL_MACHINE
+	default:
+		return 0, fmt.Errorf("unsupported hive in %s", path)
+	}
+	if create {
+		key, _, err := winreg.CreateKey(root, sub, access)
+		return key, err
+	}
+	return winreg.OpenKey(root, sub, access)
+}
+
+func (windowsRegistry) Get(key, name string) (registryValue, bool, error) {
+	k, err := openKey(key, winreg.QUERY_VALUE, false)
+	if errors.Is(err, winreg.ErrNotExist) {
+		return registryValue{}, false, nil
+	}
+	if err != nil {
+		return registryValue{}, false, err
+	}
+	defer k.Close()
+
+	_, valType, err := k.GetValue(name, nil)
+	if errors.Is(err, winreg.ErrNotExist) {
+		return registryValue{}, false, nil
+	}
+	if err != nil {
+		return registryValue{}, false, err
+	}
+	switch valType {
+	case winreg.DWORD:
+		n, _, err := k.GetIntegerValue(name)
+		return registryValue{Type: typeDWord, Data: strconv.FormatUint(n, 10)}, true, err
+	case winreg.SZ, winreg.EXPAND_SZ:
+		s, _, err := k.GetStringValue(name)
+		return registryValue{Type: typeString, Data: s}, true, err
+	}
+	// Any other type never matches, so the value gets rewritten with the defined type
+	return registryValue{Type: valueType(fmt.Sprintf("type %d", valType))}, true, nil
+}
+
+func (windowsRegistry) Set(key, name string, value registryValue) error {
+	k, err := openKey(key, winreg.SET_VALUE, true)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	if value.Type == typeDWord {
+		n, err := strconv.ParseUint(value.Data, 10, 32)
+		if err != nil {
+			return err
+		}
+		return k.SetDWordValue(name, uint32(n))
+	}
+	return k.SetStringValue(name, value.Data)
+}
+
+func (windowsRegistry) Delete(key, name string) error {
+	k, err := openKey(key, winreg.SET_VALUE, false)
+	if errors.Is(err, winreg.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+
+	if err := k.DeleteValue(name); err != nil && !errors.Is(err, winreg.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// LCTYPEs for the locale_default names used in settings.yaml
+var localeTypes = map[string]uint32{
+	"ShortDate":      0x0000001F, // LOCALE_SSHORTDATE
+	"LongDate":       0x00000020, // LOCALE_SLONGDATE
+	"TimeFormat":     0x00001003, // LOCALE_STIMEFORMAT
+	"ShortTime":      0x00000079, // LOCALE_SSHORTTIME
+	"TimeSeparator":  0x0000001E, // LOCALE_STIME
+	"FirstDayOfWeek": 0x0000100C, // LOCALE_IFIRSTDAYOFWEEK
+}
+
+const localeNoUserOverride = 0x80000000
+
+var (
+	procGetLocaleInfoEx     = windows.NewLazySystemDLL("kernel32.dll").NewProc("GetLocaleInfoEx")
+	procSendMessageTimeoutW = windows.NewLazySystemDLL("user32.dll").NewProc("SendMessageTimeoutW")
+)
+
+// Same as new CultureInfo($name, $false) in the PowerShell reset functions
+func (windowsDesktop) LocaleDefault(name string) (string, error) {
+	lcType, ok := localeTypes[name]
+	if !ok {
+		return "", fmt.Errorf("unknown locale_default '%s'", name)
+	}
+	buf := make([]uint16, 128)
+	// A nil locale name means LOCALE_NAME_USER_DEFAULT
+	n, _, err := procGetLocaleInfoEx.Call(0, uintptr(lcType|localeNoUserOverride), uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)))
+	if n == 0 {
+		return "", fmt.Errorf("GetLocaleInfoEx(%s) failed: %w", name, err)
+	}
+	return windows.UTF16ToString(buf[:n]), nil
+}
+
+func (windowsDesktop) Broadcast(area string) error {
+	const (
+		hwndBroadcast   = 0xffff
+		wmSettingChange = 0x001A
+		smtoAbortIfHung = 0x0002
+	)
+	param, err := windows.UTF16PtrFromString(area)
+	if err != nil {
+		return err
+	}
+	var result uintptr
+	ret, _, callErr := procSendMessageTimeoutW.Call(hwndBroadcast, wmSettingChange, 0,
+		uintptr(unsafe.Pointer(param)), smtoAbortIfHung, 5000, uintptr(unsafe.Pointer(&result)))
+	if ret == 0 {
+		return fmt.Errorf("WM_SETTINGCHANGE broadcast failed: %w", callErr)
+	}
+	return nil
+}
+
+// Like Stop-Process -Name explorer -Force; Windows starts the shell again by itself
+func (windowsDesktop) RestartExplorer() error {
+	out, err := exec.Command("taskkill", "/F", "/IM", "explorer.exe").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
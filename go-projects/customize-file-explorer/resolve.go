@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	configprofile "config-profile"
+	"gopkg.in/yaml.v3"
+)
+
+// resolve: print the configuration a machine ends up with once its profiles
+// are merged, with where each value came from
+func runResolve(args []string) {
+	fs := flag.NewFlagSet("resolve", flag.ExitOnError)
+	configPath := fs.String("config", "", "Path to the config.yaml file (required)")
+	settingsPath := fs.String("settings", "", "Setting definitions to use instead of the built-in settings.yaml")
+	profileName := fs.String("profile", "", "Profiles from config.yaml to apply over configuration_profile (comma-separated)")
+	hostname := fs.String("hostname", "", "Resolve for this hostname instead of this machine's")
+	domain := fs.String("domain", "", "Resolve for this domain instead of this machine's")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		fmt.Println("❌ Error: --config is required.")
+		fs.Usage()
+		os.Exit(1)
+	}
+	// Keep stdout clean for the YAML
+	log.SetOutput(os.Stderr)
+
+	sel := configprofile.ThisMachine(*profileName)
+	if *hostname != "" {
+		sel.Hostname = *hostname
+	}
+	if *domain != "" {
+		sel.Domain = *domain
+	}
+	defs := readDefinitions(*settingsPath)
+	profile, err := configprofile.Load(*configPath, profileSchema(defs), sel)
+	if err != nil {
+		log.Fatalf("❌ Invalid config:\n%v", err)
+	}
+	log.Printf("ℹ️ Resolving for hostname %q, domain %q", sel.Hostname, sel.Domain)
+	for _, l := range profile.Layers() {
+		log.Printf("   📄 %s", l)
+	}
+
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, path := range profile.Paths() {
+		parts := strings.Split(path, ".")
+		parent := root
+		for _, part := range parts[:len(parts)-1] {
+			parent = childMapping(parent, part)
+		}
+		node := &yaml.Node{Kind: yaml.ScalarNode, LineComment: profile.Source(path)}
+		if list, ok := profile.GetList(path); ok {
+			node.Kind, node.Style = yaml.SequenceNode, yaml.FlowStyle
+			for _, item := range list {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item})
+			}
+		} else {
+			node.Value, _ = profile.Get(path)
+		}
+		parent.Content = append(parent.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: parts[len(parts)-1]}, node)
+	}
+
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{
+		Kind:    yaml.MappingNode,
+		Content: []*yaml.Node{{Kind: yaml.ScalarNode, Value: "configuration_profile"}, root},
+	}}}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		log.Fatalf("❌ Failed to render YAML: %v", err)
+	}
+	fmt.Print(buf.String())
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	configprofile "config-profile"
+	"gopkg.in/yaml.v3"
+)
+
+// Setting definitions shipped with the tool; --settings replaces them
+//
+//go:embed settings.yaml
+var builtinSettings []byte
+
+// One registry value written for a setting value
+type registryWrite struct {
+	Key           string    `yaml:"key"`
+	Name          string    `yaml:"name"`
+	Type          valueType `yaml:"type"`
+	Data          string    `yaml:"data"`
+	LocaleDefault string    `yaml:"locale_default"`
+}
+
+// A configuration_profile key and what each of its allowed values writes.
+// With a format, any other value that passes the format's validation is
+// written through Other, with {value} (and {separator}) filled in.
+type settingDefinition struct {
+	Key             string                     `yaml:"key"`
+	RestartExplorer bool                       `yaml:"restart_explorer"`
+	Broadcast       string                     `yaml:"broadcast"`
+	Values          map[string][]registryWrite `yaml:"values"`
+	Format          string                     `yaml:"format"`
+	Other           []registryWrite            `yaml:"other"`
+	ReplacedBy      string                     `yaml:"replaced_by"` // older spelling of that setting
+}
+
+type settingsFile struct {
+	Settings []settingDefinition `yaml:"settings"`
+}
+
+var knownHives = []string{"HKCU", "HKLM"}
+
+// Parse and check setting definitions so a mistake in the data is reported
+// before anything is written
+func loadDefinitions(data []byte) ([]settingDefinition, error) {
+	var file settingsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Settings) == 0 {
+		return nil, fmt.Errorf("no settings defined")
+	}
+
+	seen := make(map[string]bool)
+	for i := range file.Settings {
+		def := &file.Settings[i]
+		if def.Key == "" {
+			return nil, fmt.Errorf("setting %d has no key", i+1)
+		}
+		if seen[strings.ToLower(def.Key)] {
+			return nil, fmt.Errorf("%s is defined twice", def.Key)
+		}
+		seen[strings.ToLower(def.Key)] = true
+		if len(def.Values) == 0 && def.Format == "" {
+			return nil, fmt.Errorf("%s has no values", def.Key)
+		}
+		if def.Format != "" {
+			if _, ok := valueFormats[def.Format]; !ok {
+				return nil, fmt.Errorf("%s: unknown format '%s' (use date, time or weekday)", def.Key, def.Format)
+			}
+			if len(def.Other) == 0 {
+				return nil, fmt.Errorf("%s: format %s needs other writes", def.Key, def.Format)
+			}
+			for _, w := range def.Other {
+				if err := checkWrite(w); err != nil {
+					return nil, fmt.Errorf("%s: other: %w", def.Key, err)
+				}
+			}
+		} else if len(def.Other) > 0 {
+			return nil, fmt.Errorf("%s: other writes need a format", def.Key)
+		}
+
+		// Values are matched case-insensitively
+		values := make(map[string][]registryWrite, len(def.Values))
+		for value, writes := range def.Values {
+			if len(writes) == 0 {
+				return nil, fmt.Errorf("%s: value '%s' writes nothing", def.Key, value)
+			}
+			for _, w := range writes {
+				if err := checkWrite(w); err != nil {
+					return nil, fmt.Errorf("%s: value '%s': %w", def.Key, value, err)
+				}
+			}
+			values[strings.ToLower(value)] = writes
+		}
+		def.Values = values
+	}
+	for _, def := range file.Settings {
+		if def.ReplacedBy != "" && !seen[strings.ToLower(def.ReplacedBy)] {
+			return nil, fmt.Errorf("%s: replaced_by names %s, which is not defined", def.Key, def.ReplacedBy)
+		}
+	}
+	return file.Settings, nil
+}
+
+func checkWrite(w registryWrite) error {
+	hive, _, _ := strings.Cut(w.Key, `\`)
+	known := false
+	for _, h := range knownHives {
+		known = known || strings.EqualFold(hive, h)
+	}
+	switch {
+	case !known:
+		return fmt.Errorf("key '%s' must start with one of %s", w.Key, strings.Join(knownHives, ", "))
+	case w.Name == "":
+		return fmt.Errorf("%s: value name is missing", w.Key)
+	case w.Type != typeDWord && w.Type != typeString:
+		return fmt.Errorf("%s\\%s: unknown type '%s' (use dword or string)", w.Key, w.Name, w.Type)
+	case w.LocaleDefault != "" && w.Data != "":
+		return fmt.Errorf("%s\\%s: use either data or locale_default, not both", w.Key, w.Name)
+	}
+	for _, m := range placeholderRe.FindAllStringSubmatch(w.Data, -1) {
+		if m[1] != "value" && m[1] != "separator" {
+			return fmt.Errorf("%s\\%s: unknown placeholder {%s}", w.Key, w.Name, m[1])
+		}
+	}
+	if w.Type == typeDWord && w.LocaleDefault == "" && !placeholderRe.MatchString(w.Data) {
+		if _, err := strconv.ParseUint(w.Data, 0, 32); err != nil {
+			return fmt.Errorf("%s\\%s: '%s' is not a DWORD", w.Key, w.Name, w.Data)
+		}
+	}
+	return nil
+}
+
+var placeholderRe = regexp.MustCompile(`\{(\w+)\}`)
+
+// Registry writes for a config value: one of the listed values, or for a
+// setting with a format any value that passes its validation
+func writesFor(def settingDefinition, value string) ([]registryWrite, error) {
+	if writes, ok := def.Values[strings.ToLower(value)]; ok {
+		return writes, nil
+	}
+	if def.Format == "" {
+		return nil, fmt.Errorf("'%s' is not one of: %s", value, strings.Join(allowedValues(def), ", "))
+	}
+	placeholders, err := valueFormats[def.Format].toData(value)
+	if err != nil {
+		return nil, err
+	}
+	writes := make([]registryWrite, len(def.Other))
+	for i, w := range def.Other {
+		w.Data = placeholderRe.ReplaceAllStringFunc(w.Data, func(m string) string {
+			return placeholders[m[1:len(m)-1]]
+		})
+		writes[i] = w
+	}
+	return writes, nil
+}
+
+// The listed values of a setting, sorted
+func allowedValues(def settingDefinition) []string {
+	values := make([]string, 0, len(def.Values))
+	for value := range def.Values {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// The shared config.yaml schema with a field for every defined setting, so
+// config.yaml is checked against the same definitions that are applied
+func profileSchema(defs []settingDefinition) map[string]*configprofile.Field {
+	schema := configprofile.Schema()
+	for _, def := range defs {
+		fields, name := schema, def.Key
+		if i := strings.LastIndex(def.Key, "."); i >= 0 {
+			fields, name = configprofile.Section(schema, def.Key[:i]), def.Key[i+1:]
+		}
+		field := &configprofile.Field{Values: allowedValues(def)}
+		if def.Format != "" {
+			format := valueFormats[def.Format]
+			field.Check = func(value string) error {
+				_, err := format.toData(value)
+				return err
+			}
+		}
+		fields[strings.ToLower(name)] = field
+	}
+	return schema
+}
+
+// Whether two config values mean the same. Listed values and weekdays ignore
+// case; date and time patterns do not (MM is months, mm is minutes).
+func sameValue(def settingDefinition, a, b string) bool {
+	_, listed := def.Values[strings.ToLower(a)]
+	if listed || def.Format == "weekday" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// The data a write should leave in the registry
+func desiredValue(w registryWrite, desk desktop) (registryValue, error) {
+	data := w.Data
+	if w.LocaleDefault != "" {
+		value, err := desk.LocaleDefault(w.LocaleDefault)
+		if err != nil {
+			return registryValue{}, err
+		}
+		data = value
+	}
+	if w.Type == typeDWord {
+		n, err := strconv.ParseUint(data, 0, 32)
+		if err != nil {
+			return registryValue{}, fmt.Errorf("'%s' is not a DWORD", data)
+		}
+		data = strconv.FormatUint(n, 10)
+	}
+	return registryValue{Type: w.Type, Data: data}, nil
+}
+
+// A registry value that has to change, with what it held before
+type pendingWrite struct {
+	Setting  string
+	Key      string
+	Name     string
+	Desired  registryValue
+	Previous registryValue
+	Existed  bool
+}
+
+type applyResult struct {
+	Changed   int // registry values written
+	Unchanged int // registry values that already had the desired data
+	Restart   bool
+}
+
+// Apply every defined setting present in the profile as one transaction.
+// All changes are worked out and the previous values snapshotted first; if
+// any write fails, everything already written is put back. Broadcasts and the
+// Explorer restart happen once, after all writes succeeded, and only for
+// settings that actually changed.
+func applySettings(reg registry, desk desktop, defs []settingDefinition, profile *configprofile.Profile) (applyResult, error) {
+	var result applyResult
+	var pending []pendingWrite
+	var broadcasts []string
+	broadcastSeen := make(map[string]bool)
+	claimed := make(map[string]pendingWrite) // registry value → the setting that wants it
+
+	for _, def := range defs {
+		strVal, exists := profile.Get(def.Key)
+		if !exists {
+			// An older setting is not missing; the one replacing it warns
+			if def.ReplacedBy == "" {
+				log.Printf("⚠️  Key not found in YAML: %s", def.Key)
+			}
+			continue
+		}
+		// The profile has been checked against these definitions already
+		writes, err := writesFor(def, strVal)
+		if err != nil {
+			return result, fmt.Errorf("%s = %s: %w", def.Key, strVal, err)
+		}
+
+		changed := 0
+		for _, w := range writes {
+			desired, err := desiredValue(w, desk)
+			if err != nil {
+				return result, fmt.Errorf("%s = %s: %w", def.Key, strVal, err)
+			}
+			path := registryPath(w.Key, w.Name)
+			if other, ok := claimed[path]; ok && other.Desired != desired {
+				return result, fmt.Errorf("%s and %s both set %s\\%s, to %q and %q", other.Setting, def.Key, w.Key, w.Name, other.Desired.Data, desired.Data)
+			}
+			claimed[path] = pendingWrite{Setting: def.Key, Desired: desired}
+
+			current, found, err := reg.Get(w.Key, w.Name)
+			if err != nil {
+				return result, fmt.Errorf("failed to read %s\\%s: %w", w.Key, w.Name, err)
+			}
+			if found && current == desired {
+				result.Unchanged++
+				continue
+			}
+			pending = append(pending, pendingWrite{def.Key, w.Key, w.Name, desired, current, found})
+			changed++
+		}
+
+		if changed == 0 {
+			log.Printf("✔️  %s = %s (already set)", def.Key, strVal)
+			continue
+		}
+		log.Printf("✔️  %s = %s", def.Key, strVal)
+		if def.Broadcast != "" && !broadcastSeen[def.Broadcast] {
+			broadcastSeen[def.Broadcast] = true
+			broadcasts = append(broadcasts, def.Broadcast)
+		}
+		if def.RestartExplorer {
+			result.Restart = true
+		}
+	}
+
+	if len(pending) == 0 {
+		return result, nil
+	}
+
+	// Snapshot, so a failed run can also be undone by hand from the log
+	for _, p := range pending {
+		previous := "(not set)"
+		if p.Existed {
+			previous = p.Previous.Data
+		}
+		log.Printf("   📸 %s\\%s was %s", p.Key, p.Name, previous)
+	}
+
+	for i, p := range pending {
+		if err := reg.Set(p.Key, p.Name, p.Desired); err != nil {
+			log.Printf("❌ Failed to write %s\\%s; rolling back %d change(s)", p.Key, p.Name, i)
+			rollback(reg, pending[:i])
+			return applyResult{}, fmt.Errorf("%s: failed to write %s\\%s: %w (all changes rolled back)", p.Setting, p.Key, p.Name, err)
+		}
+		log.Printf("   📝 %s\\%s = %s", p.Key, p.Name, p.Desired.Data)
+	}
+	result.Changed = len(pending)
+
+	for _, area := range broadcasts {
+		log.Printf("📣 Broadcasting %s change", area)
+		if err := desk.Broadcast(area); err != nil {
+			log.Printf("⚠️  Failed to broadcast %s change: %v", area, err)
+		}
+	}
+	if result.Restart {
+		log.Println("🔁 Restarting Explorer...")
+		if err := desk.RestartExplorer(); err != nil {
+			log.Printf("⚠️ Failed to restart Explorer: %v", err)
+		}
+	}
+	return result, nil
+}
+
+// Put back the previous values of writes that were applied, newest first
+func rollback(reg registry, applied []pendingWrite) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		p := applied[i]
+		var err error
+		if p.Existed {
+			err = reg.Set(p.Key, p.Name, p.Previous)
+		} else {
+			err = reg.Delete(p.Key, p.Name)
+		}
+		if err != nil {
+			log.Printf("⚠️  Could not restore %s\\%s: %v", p.Key, p.Name, err)
+			continue
+		}
+		log.Printf("   ↩️  %s\\%s restored", p.Key, p.Name)
+	}
+}
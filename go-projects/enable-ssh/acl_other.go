@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+func secureKeyFile(path, owner string) error {
+	return os.Chmod(path, 0600)
+}
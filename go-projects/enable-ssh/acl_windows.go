@@ -0,0 +1,23 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// sshd ignores key files others can write to. Leave only SYSTEM,
+// Administrators and (for a user's own file) the user, without inherited
+// entries; administrators_authorized_keys must not grant any user access.
+func secureKeyFile(path, owner string) error {
+	args := []string{path, "/inheritance:r", "/grant", "*S-1-5-18:F", "/grant", "*S-1-5-32-544:F"}
+	if owner != "administrators" {
+		args = append(args, "/grant", owner+":F")
+	}
+	if out, err := exec.Command("icacls", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("icacls: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -2,4 +2,13 @@ module enable-ssh
 
 go 1.24.4
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	config-profile v0.0.0
+	golang.org/x/crypto v0.39.0
+	golang.org/x/sys v0.33.0
+)
+
+require gopkg.in/yaml.v3 v3.0.1 // indirect
+
+// Shared config.yaml loader, kept next to the tools that use it
+replace config-profile => ../config-profile
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// One of sshd's host keys, as clients will see it
+type hostKey struct {
+	Type        string `json:"type"`
+	Fingerprint string `json:"fingerprint"`
+	File        string `json:"file"`
+	key         ssh.PublicKey
+}
+
+// What --host-keys-json writes
+type hostKeyReport struct {
+	Hosts []string  `json:"hosts"`
+	Port  string    `json:"port"`
+	Keys  []hostKey `json:"keys"`
+}
+
+// The names clients use to reach this machine: the ones given on the command
+// line, or the hostname and, in a domain, the full name
+func knownHostNames(names string) []string {
+	if names != "" {
+		var list []string
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				list = append(list, name)
+			}
+		}
+		return list
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil
+	}
+	hostname = strings.ToLower(hostname)
+	list := []string{hostname}
+	if domain := strings.ToLower(os.Getenv("USERDNSDOMAIN")); domain != "" {
+		list = append(list, hostname+"."+domain)
+	}
+	return list
+}
+
+// Read the ssh_host_*_key.pub files sshd generated in dir
+func readHostKeys(dir string) ([]hostKey, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "ssh_host_*_key.pub"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var keys []hostKey
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: not a valid public key: %v", file, err)
+		}
+		keys = append(keys, hostKey{
+			Type:        key.Type(),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			File:        file,
+			key:         key,
+		})
+	}
+	return keys, nil
+}
+
+// Log the fingerprints of sshd's host keys and write them in known_hosts
+// format (and as JSON if jsonPath is set), so clients can check the host on
+// their first connection instead of trusting it blindly
+func exportHostKeys(dir string, names []string, port, knownHostsPath, jsonPath string) error {
+	keys, err := readHostKeys(dir)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		log.Printf("⚠️  No host keys in %s yet; sshd creates them the first time it starts", dir)
+		return nil
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no host names to write known_hosts for; pass --host-names")
+	}
+
+	// Non-standard ports are written as [host]:port, as ssh looks them up
+	addresses := make([]string, len(names))
+	for i, name := range names {
+		addresses[i] = net.JoinHostPort(name, port)
+	}
+	log.Printf("🔐 Host key fingerprints for %s:", strings.Join(names, ", "))
+	var lines []string
+	for _, k := range keys {
+		log.Printf("   %s %s", k.Type, k.Fingerprint)
+		lines = append(lines, knownhosts.Line(addresses, k.key))
+	}
+
+	if err := writeIfChanged(knownHostsPath, []byte(strings.Join(lines, "\n")+"\n")); err != nil {
+		return err
+	}
+	if jsonPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(hostKeyReport{Hosts: names, Port: port, Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeIfChanged(jsonPath, append(data, '\n'))
+}
+
+// Write data to path unless it already holds it, and say which
+func writeIfChanged(path string, data []byte) error {
+	existing, err := os.ReadFile(path)
+	if err == nil && string(existing) == string(data) {
+		log.Printf("✔️ %s already up to date", path)
+		return nil
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	log.Printf("📝 Wrote %s", path)
+	return nil
+}
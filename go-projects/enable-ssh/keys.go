@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	configprofile "config-profile"
+	"golang.org/x/crypto/ssh"
+)
+
+// One public key from config.yaml or an existing authorized_keys file
+type authorizedKey struct {
+	Line   string // as it goes in authorized_keys
+	Key    ssh.PublicKey
+	Source string // where it came from, for messages
+}
+
+func (k authorizedKey) fingerprint() string {
+	fingerprint := ssh.FingerprintSHA256(k.Key)
+	if comment := keyComment(k.Line); comment != "" {
+		fingerprint += " " + comment
+	}
+	return fingerprint
+}
+
+// The comment at the end of an authorized_keys line, if any
+func keyComment(line string) string {
+	_, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return ""
+	}
+	return comment
+}
+
+// The identity of a key: its type and key data, not options or comment
+func keyID(key ssh.PublicKey) string {
+	return string(key.Marshal())
+}
+
+// Who gets which keys: ssh.authorized keys.<user>, where "administrators"
+// means the shared administrators_authorized_keys file
+func keyTargets(profile *configprofile.Profile) map[string][]string {
+	targets := make(map[string][]string)
+	for _, path := range profile.Paths() {
+		if name, ok := strings.CutPrefix(path, "ssh.authorized keys."); ok {
+			targets[name], _ = profile.GetList(path)
+		}
+	}
+	return targets
+}
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Read every key a config entry stands for: a key line, an https:// URL
+// serving key lines (like https://github.com/<user>.keys), a key file, or a
+// directory whose .pub files are read
+func readKeySource(source string) ([]authorizedKey, error) {
+	if configprofile.IsKeyLine(source) {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(source))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid public key: %v", source, err)
+		}
+		return []authorizedKey{{Line: strings.TrimSpace(source), Key: key, Source: "config.yaml"}}, nil
+	}
+
+	if strings.HasPrefix(source, "https://") {
+		resp, err := httpClient.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("%s: %s", source, resp.Status)
+		}
+		return parseKeys(resp.Body, source)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{source}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(source, "*.pub")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+	var keys []authorizedKey
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		found, err := parseKeys(f, file)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, found...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no public keys found", source)
+	}
+	return keys, nil
+}
+
+// Parse authorized_keys-style text; blank lines and # comments are skipped,
+// anything else has to be a valid key
+func parseKeys(r io.Reader, source string) ([]authorizedKey, error) {
+	var keys []authorizedKey
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: not a valid public key: %v", source, n, err)
+		}
+		keys = append(keys, authorizedKey{Line: line, Key: key, Source: source})
+	}
+	return keys, scanner.Err()
+}
+
+// The authorized_keys file sshd reads for a user, as config (the
+// sshd_config just rendered) sets it. "administrators" is the file of the
+// Match Group administrators block, which members of the Administrators
+// group use instead of their own (as in the stock sshd_config).
+func authorizedKeysPath(config *sshdConfig, name string) (string, error) {
+	if name == "administrators" {
+		file := config.authorizedKeysFile("group", "administrators")
+		if file == "" {
+			return "", fmt.Errorf("sshd_config has no Match Group administrators block with an AuthorizedKeysFile")
+		}
+		return expandKeysPath(file, nil), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	file := config.authorizedKeysFile("user", name)
+	if file == "" {
+		file = config.authorizedKeysFile("", "")
+	}
+	if file == "" {
+		file = ".ssh/authorized_keys" // sshd's default
+	}
+	return expandKeysPath(file, u), nil
+}
+
+// Expand the tokens OpenSSH for Windows allows in AuthorizedKeysFile; a
+// relative path is relative to the user's home
+func expandKeysPath(file string, u *user.User) string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	var home, name string
+	if u != nil {
+		home, name = u.HomeDir, u.Username
+	}
+	file = strings.NewReplacer("__PROGRAMDATA__", programData, "%h", home, "%u", name, "%%", "%").Replace(file)
+	if !filepath.IsAbs(file) && !windowsPathRe.MatchString(file) && home != "" {
+		file = filepath.Join(home, file)
+	}
+	return filepath.Clean(file)
+}
+
+// C:\... or C:/..., which filepath.IsAbs does not know outside Windows
+var windowsPathRe = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// Make the authorized_keys file at path hold keys. Lines that are already
+// there are kept as they are (options and comments included), and a key
+// listed twice keeps its first line. Keys this tool added on an earlier run,
+// recorded in path.managed, are removed once config.yaml stops listing
+// them; keys added by hand are never removed. The first change keeps the
+// original as path.bak. Returns what was added and removed.
+func syncAuthorizedKeys(path, owner string, keys []authorizedKey) (added, removed []authorizedKey, err error) {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	managedPath := path + ".managed"
+	previous, err := readManagedKeys(managedPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wanted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		wanted[keyID(k.Key)] = true
+	}
+	present := make(map[string]bool)
+	var lines []string
+	text := strings.ReplaceAll(string(existing), "\r\n", "\n")
+	for n, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			if len(existing) > 0 {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(trimmed))
+		if err != nil {
+			log.Printf("⚠️  %s:%d: keeping a line that is not a valid key", path, n+1)
+			lines = append(lines, line)
+			continue
+		}
+		id := keyID(key)
+		switch {
+		case !wanted[id] && previous[id]:
+			removed = append(removed, authorizedKey{Line: trimmed, Key: key, Source: path})
+			continue
+		case !wanted[id]:
+			// Not ours: leave it alone
+		case present[id]:
+			continue // duplicate of a line already kept
+		}
+		present[id] = true
+		lines = append(lines, line)
+	}
+	var managed []authorizedKey
+	for _, k := range keys {
+		id := keyID(k.Key)
+		if !present[id] {
+			present[id] = true
+			lines = append(lines, k.Line)
+			added = append(added, k)
+			managed = append(managed, k)
+		} else if previous[id] {
+			managed = append(managed, k)
+		}
+	}
+
+	rendered := ""
+	if len(lines) > 0 {
+		rendered = strings.Join(lines, "\n") + "\n"
+	}
+	if rendered != string(existing) {
+		// Back up only the file as it was before enable-ssh first changed it,
+		// as writeSSHDConfig does
+		backupPath := path + ".bak"
+		if _, err := os.Stat(backupPath); len(existing) > 0 && os.IsNotExist(err) {
+			if err := os.WriteFile(backupPath, existing, 0600); err != nil {
+				return nil, nil, fmt.Errorf("failed to back up %s: %w", path, err)
+			}
+			if err := secureKeyFile(backupPath, owner); err != nil {
+				return nil, nil, fmt.Errorf("failed to restrict access to %s: %w", backupPath, err)
+			}
+			log.Printf("🔁 Existing file backed up: %s → %s", path, backupPath)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, nil, err
+		}
+		if err := os.WriteFile(path, []byte(rendered), 0600); err != nil {
+			return nil, nil, err
+		}
+		if err := secureKeyFile(path, owner); err != nil {
+			return nil, nil, fmt.Errorf("failed to restrict access to %s: %w", path, err)
+		}
+	}
+	if err := writeManagedKeys(managedPath, owner, managed); err != nil {
+		return nil, nil, err
+	}
+	return added, removed, nil
+}
+
+// The keys syncAuthorizedKeys added to an authorized_keys file, by keyID
+func readManagedKeys(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys, err := parseKeys(f, path)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		ids[keyID(k.Key)] = true
+	}
+	return ids, nil
+}
+
+// Record the keys this tool manages next to the authorized_keys file, or
+// remove the record when there are none
+func writeManagedKeys(path, owner string, keys []authorizedKey) error {
+	if len(keys) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Keys enable-ssh added to %s, removed again once\n", filepath.Base(strings.TrimSuffix(path, ".managed")))
+	b.WriteString("# config.yaml stops listing them. Other keys there are left alone.\n")
+	for _, k := range keys {
+		b.WriteString(k.Line + "\n")
+	}
+	if existing, err := os.ReadFile(path); err == nil && string(existing) == b.String() {
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return secureKeyFile(path, owner)
+}
+
+// The authorized_keys files provisionKeys has added keys to, kept next to
+// sshd_config so that keys are taken out again once config.yaml no longer
+// names their user at all
+func managedFilesPath(sshdConfigPath string) string {
+	return filepath.Join(filepath.Dir(sshdConfigPath), "enable-ssh-keys.managed")
+}
+
+// One authorized_keys file to sync, and whose it is
+type keyTarget struct {
+	Name string // a user, or "administrators"
+	Path string
+	Keys []authorizedKey
+}
+
+// The files recorded by writeManagedFiles, one "<user>\t<path>" line each
+func readManagedFiles(path string) ([]keyTarget, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var targets []keyTarget
+	for n, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, file, ok := strings.Cut(line, "\t")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected <user><tab><path>", path, n+1)
+		}
+		targets = append(targets, keyTarget{Name: name, Path: file})
+	}
+	return targets, nil
+}
+
+// Record the targets that have keys enable-ssh manages, or remove the record
+// when none do
+func writeManagedFiles(path string, targets []keyTarget) error {
+	var b strings.Builder
+	b.WriteString("# authorized_keys files enable-ssh added keys to (see their .managed\n")
+	b.WriteString("# files), so they are cleaned up when config.yaml drops the user.\n")
+	count := 0
+	for _, t := range targets {
+		if _, err := os.Stat(t.Path + ".managed"); err == nil {
+			fmt.Fprintf(&b, "%s\t%s\n", t.Name, t.Path)
+			count++
+		}
+	}
+	if count == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if existing, err := os.ReadFile(path); err == nil && string(existing) == b.String() {
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return secureKeyFile(path, "administrators")
+}
+
+// Provision ssh.authorized keys into the files the sshd_config at
+// sshdConfigPath names. Files keys were added to on an earlier run are
+// synced too, so keys of users config.yaml no longer lists are removed.
+// Every source is read and checked before any file is written.
+func provisionKeys(profile *configprofile.Profile, sshdConfigPath string) error {
+	listed := keyTargets(profile)
+	names := make([]string, 0, len(listed))
+	for name := range listed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	text, err := os.ReadFile(sshdConfigPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	config := parseSSHDConfig(string(text))
+
+	var targets []keyTarget
+	for _, name := range names {
+		path, err := authorizedKeysPath(config, name)
+		if err != nil {
+			return fmt.Errorf("authorized keys for %s: %w", name, err)
+		}
+		target := keyTarget{Name: name, Path: path}
+		seen := make(map[string]bool)
+		for _, source := range listed[name] {
+			keys, err := readKeySource(source)
+			if err != nil {
+				return fmt.Errorf("authorized keys for %s: %w", name, err)
+			}
+			for _, k := range keys {
+				if !seen[keyID(k.Key)] {
+					seen[keyID(k.Key)] = true
+					target.Keys = append(target.Keys, k)
+				}
+			}
+		}
+		targets = append(targets, target)
+	}
+
+	// Files from earlier runs that nothing lists now: sync them to no keys
+	listPath := managedFilesPath(sshdConfigPath)
+	earlier, err := readManagedFiles(listPath)
+	if err != nil {
+		return err
+	}
+	for _, e := range earlier {
+		if !slices.ContainsFunc(targets, func(t keyTarget) bool { return t.Path == e.Path }) {
+			targets = append(targets, e)
+		}
+	}
+
+	for _, t := range targets {
+		added, removed, err := syncAuthorizedKeys(t.Path, t.Name, t.Keys)
+		if err != nil {
+			return fmt.Errorf("authorized keys for %s: %w", t.Name, err)
+		}
+		if len(added) == 0 && len(removed) == 0 {
+			log.Printf("✔️  %s: %d key(s) already authorized", t.Path, len(t.Keys))
+			continue
+		}
+		for _, k := range added {
+			log.Printf("   ➕ %s (%s)", k.fingerprint(), k.Source)
+		}
+		for _, k := range removed {
+			log.Printf("   ➖ %s", k.fingerprint())
+		}
+		log.Printf("✅ %s: %d added, %d removed", t.Path, len(added), len(removed))
+	}
+	return writeManagedFiles(listPath, targets)
+}
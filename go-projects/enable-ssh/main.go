@@ -6,22 +6,23 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"gopkg.in/yaml.v3"
+	configprofile "config-profile"
 )
 
-type Config struct {
-	ConfigurationProfile struct {
-		SSH string `yaml:"ssh"`
-	} `yaml:"configuration_profile"`
-}
-
 func main() {
 	yamlPath := flag.String("yaml", "", "Path to config.yaml (required)")
 	modulePath := flag.String("module", "", "Path to PowerShell module (.psm1) (required)")
 	logPath := flag.String("log", "", "Path to log file (required)")
+	profileName := flag.String("profile", "", "Profiles from config.yaml to apply over configuration_profile (comma-separated)")
+	sshdConfigPath := flag.String("sshd-config", defaultSSHDConfigPath(), "sshd_config to manage when ssh: is a section")
+	knownHostsPath := flag.String("known-hosts", "", "Where to write this machine's host keys in known_hosts format (default: <hostname>_known_hosts next to the log)")
+	hostKeysJSON := flag.String("host-keys-json", "", "Also write the host key fingerprints as JSON to this file")
+	hostNames := flag.String("host-names", "", "Names clients use for this machine, for known_hosts (comma-separated; default: hostname and hostname.domain)")
+	restoreConfig := flag.Bool("restore-config", false, "When ssh: is off, put back the sshd_config saved before enable-ssh first changed it, or remove it if there was none")
 	flag.Parse()
 
 	if *yamlPath == "" || *modulePath == "" || *logPath == "" {
@@ -38,44 +39,121 @@ func main() {
 	}
 	defer logFile.Close()
 	log.SetOutput(logFile)
+	if *knownHostsPath == "" {
+		hostname, _ := os.Hostname()
+		*knownHostsPath = filepath.Join(filepath.Dir(*logPath), strings.ToLower(hostname)+"_known_hosts")
+	}
 
-	// Load YAML
-	data, err := os.ReadFile(*yamlPath)
+	// Load YAML; unknown keys or values stop here, before anything runs
+	profile, err := configprofile.Load(*yamlPath, configprofile.Schema(), configprofile.ThisMachine(*profileName))
 	if err != nil {
-		log.Fatalf("❌ Failed to read YAML file: %v", err)
+		log.Fatalf("❌ Invalid config:\n%v", err)
 	}
-
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		log.Fatalf("❌ Failed to parse YAML file: %v", err)
+	for _, l := range profile.Layers()[1:] {
+		log.Printf("ℹ️ Using %s", l)
+	}
+	ssh, err := readSSHSettings(profile)
+	if err != nil {
+		log.Fatalf("❌ Invalid config: %v", err)
 	}
 
-	if strings.ToLower(strings.TrimSpace(cfg.ConfigurationProfile.SSH)) != "on" {
-		log.Println("ℹ️ SSH is disabled in config.yaml. Skipping SSH setup.")
+	if !ssh.Configured {
+		log.Println("ℹ️ No ssh: in config.yaml. Skipping SSH setup.")
+		return
+	}
+	if !ssh.Enabled {
+		disableSSH(*modulePath, *sshdConfigPath, *restoreConfig, logFile)
 		return
 	}
 
-	// Generate PowerShell script content
-	script := fmt.Sprintf(`Import-Module '%s'
-Enable-SSH
-Enable-SSHFirewallRule
-`, *modulePath)
+	log.Println("🔐 Running Enable-SSH and Enable-SSHFirewallRule...")
+	start := time.Now()
+	if err := runModule(*modulePath, logFile, "Enable-SSH", "Enable-SSHFirewallRule -Port "+ssh.port()); err != nil {
+		log.Fatalf("❌ SSH setup failed: %v", err)
+	}
+	duration := time.Since(start).Seconds()
+	log.Printf("✅ SSH setup completed in %.2f seconds.", duration)
+
+	if ssh.Managed {
+		configureSSHD(ssh, *sshdConfigPath, logFile)
+	} else if err := provisionKeys(ssh.profile, *sshdConfigPath); err != nil {
+		// ssh: on lists no keys, but keys added on an earlier run still have to go
+		log.Fatalf("❌ Failed to remove authorized keys: %v", err)
+	}
+
+	// Host keys sit next to sshd_config; sshd creates them the first time it starts
+	names := knownHostNames(*hostNames)
+	if err := exportHostKeys(filepath.Dir(*sshdConfigPath), names, ssh.port(), *knownHostsPath, *hostKeysJSON); err != nil {
+		log.Fatalf("❌ Failed to export host keys: %v", err)
+	}
+}
+
+// ssh: is a section: render sshd_config, set the default shell and provision
+// keys, then restart sshd if its config changed
+func configureSSHD(ssh sshSettings, sshdConfigPath string, logFile *os.File) {
+	log.Println("📄 Updating", sshdConfigPath)
+	changed, err := writeSSHDConfig(sshdConfigPath, ssh.directives())
+	if err != nil {
+		log.Fatalf("❌ Failed to update sshd_config: %v", err)
+	}
+	if ssh.Shell != "" {
+		shellChanged, err := setDefaultShell(ssh.Shell)
+		if err != nil {
+			log.Fatalf("❌ Failed to set the default shell: %v", err)
+		}
+		if shellChanged {
+			log.Println("✅ Default shell set to", ssh.Shell)
+		}
+	}
+
+	// Keys are read at each login, so they need no restart
+	if err := provisionKeys(ssh.profile, sshdConfigPath); err != nil {
+		log.Fatalf("❌ Failed to provision authorized keys: %v", err)
+	}
 
+	if !changed {
+		log.Println("✔️ sshd_config already up to date; sshd left running")
+		return
+	}
+	log.Println("🔁 sshd_config changed; restarting sshd...")
+	restart := exec.Command("powershell", "-NoProfile", "-Command", "Restart-Service -Name sshd -ErrorAction Stop")
+	restart.Stdout = logFile
+	restart.Stderr = logFile
+	if err := restart.Run(); err != nil {
+		log.Fatalf("❌ Failed to restart sshd: %v", err)
+	}
+	log.Println("✅ sshd restarted with the new config")
+}
+
+// Run module commands through a generated script, with -NoProfile to avoid
+// profile interference
+func runModule(modulePath string, logFile *os.File, commands ...string) error {
+	script := fmt.Sprintf("Import-Module '%s'\n%s\n", modulePath, strings.Join(commands, "\n"))
 	scriptName := "enable-ssh.ps1"
 	if err := os.WriteFile(scriptName, []byte(script), 0644); err != nil {
-		log.Fatalf("❌ Failed to write PowerShell script: %v", err)
+		return fmt.Errorf("failed to write PowerShell script: %w", err)
 	}
 
-	// Execute PowerShell script with -NoProfile to avoid profile interference
-	log.Println("🔐 Running Enable-SSH and Enable-SSHFirewallRule...")
 	cmd := exec.Command("powershell", "-NoProfile", "-ExecutionPolicy", "Bypass", "-File", scriptName)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
+	return cmd.Run()
+}
 
+// ssh: off (or ssh.enabled: off): stop and disable sshd and remove the firewall
+// rule Enable-SSHFirewallRule made. Each step checks first, so running it
+// again changes nothing.
+func disableSSH(modulePath, sshdConfigPath string, restoreConfig bool, logFile *os.File) {
+	log.Println("🛑 SSH is off in config.yaml; running Disable-SSH and Remove-SSHFirewallRule...")
 	start := time.Now()
-	if err := cmd.Run(); err != nil {
-		log.Fatalf("❌ SSH setup failed: %v", err)
+	if err := runModule(modulePath, logFile, "Disable-SSH", "Remove-SSHFirewallRule"); err != nil {
+		log.Fatalf("❌ Turning SSH off failed: %v", err)
 	}
-	duration := time.Since(start).Seconds()
-	log.Printf("✅ SSH setup completed in %.2f seconds.", duration)
-}
\ No newline at end of file
+
+	if restoreConfig {
+		if err := restoreSSHDConfig(sshdConfigPath); err != nil {
+			log.Fatalf("❌ Failed to restore sshd_config: %v", err)
+		}
+	}
+	log.Printf("✅ SSH turned off in %.2f seconds.", time.Since(start).Seconds())
+}
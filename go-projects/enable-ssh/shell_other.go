@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+func setDefaultShell(path string) (bool, error) {
+	return false, errors.New("the OpenSSH default shell can only be set on Windows")
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows/registry"
+)
+
+// OpenSSH for Windows takes the login shell from the registry, not sshd_config.
+// Reports whether the value changed.
+func setDefaultShell(path string) (bool, error) {
+	key, _, err := registry.CreateKey(registry.LOCAL_MACHINE, `SOFTWARE\OpenSSH`, registry.QUERY_VALUE|registry.SET_VALUE)
+	if err != nil {
+		return false, err
+	}
+	defer key.Close()
+
+	if current, _, err := key.GetStringValue("DefaultShell"); err == nil && current == path {
+		return false, nil
+	}
+	return true, key.SetStringValue("DefaultShell", path)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	configprofile "config-profile"
+)
+
+// What the default shell names stand for
+var shellPaths = map[string]string{
+	"pwsh":       `C:\Program Files\PowerShell\7\pwsh.exe`,
+	"powershell": `C:\Windows\System32\WindowsPowerShell\v1.0\powershell.exe`,
+	"cmd":        `C:\Windows\System32\cmd.exe`,
+}
+
+// The ssh: part of the profile
+type sshSettings struct {
+	Configured bool // ssh: is in config.yaml at all
+	Enabled    bool
+	Managed    bool // ssh: is a section, so sshd_config is managed too
+	Shell      string
+	profile    *configprofile.Profile
+}
+
+var subsystemNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func readSSHSettings(profile *configprofile.Profile) (sshSettings, error) {
+	settings := sshSettings{profile: profile, Configured: profile.Has("ssh")}
+	if value, ok := profile.Get("ssh"); ok {
+		settings.Enabled = value == "on"
+		return settings, nil
+	}
+	if !settings.Configured {
+		return settings, nil
+	}
+
+	settings.Managed = true
+	settings.Enabled = true
+	if value, ok := profile.Get("ssh.enabled"); ok {
+		settings.Enabled = value == "on"
+	}
+	if shell, ok := profile.Get("ssh.default shell"); ok {
+		settings.Shell = shell
+		if path, ok := shellPaths[shell]; ok {
+			settings.Shell = path
+		}
+	}
+
+	password, _ := profile.Get("ssh.password authentication")
+	pubkey, _ := profile.Get("ssh.public key authentication")
+	if password == "off" && pubkey == "off" {
+		return settings, fmt.Errorf("line %d: ssh: password and public key authentication are both off; nobody could log in", profile.Line("ssh.password authentication"))
+	}
+
+	// Subsystems are free-form, so they are checked here rather than by the schema
+	for _, path := range profile.Paths() {
+		name, ok := strings.CutPrefix(path, "ssh.subsystems.")
+		if !ok {
+			continue
+		}
+		command, isValue := profile.Get(path)
+		switch {
+		case strings.Contains(name, ".") || !isValue:
+			return settings, fmt.Errorf("line %d: %s: expected subsystem: command", profile.Line(path), path)
+		case !subsystemNameRe.MatchString(name):
+			return settings, fmt.Errorf("line %d: %s: '%s' is not a subsystem name", profile.Line(path), path, name)
+		case strings.TrimSpace(command) == "":
+			return settings, fmt.Errorf("line %d: %s: no command", profile.Line(path), path)
+		}
+	}
+	return settings, nil
+}
+
+func yesNo(value string) string {
+	if value == "on" {
+		return "yes"
+	}
+	return "no"
+}
+
+// Quote names with spaces, which sshd would otherwise split
+func quoteArgs(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = value
+		if strings.ContainsAny(value, " \t") {
+			quoted[i] = `"` + value + `"`
+		}
+	}
+	return quoted
+}
+
+// The sshd_config directives the section asks for; keys it leaves out are
+// left alone in the file
+func (s sshSettings) directives() []directive {
+	var directives []directive
+	profile := s.profile
+	if port, ok := profile.Get("ssh.port"); ok {
+		directives = append(directives, directive{Keyword: "Port", Lines: [][]string{{port}}})
+	}
+	if addresses, ok := profile.GetList("ssh.listen addresses"); ok {
+		d := directive{Keyword: "ListenAddress"}
+		for _, address := range addresses {
+			d.Lines = append(d.Lines, []string{address})
+		}
+		directives = append(directives, d)
+	}
+	for key, keyword := range map[string]string{
+		"ssh.password authentication":   "PasswordAuthentication",
+		"ssh.public key authentication": "PubkeyAuthentication",
+	} {
+		if value, ok := profile.Get(key); ok {
+			directives = append(directives, directive{Keyword: keyword, Lines: [][]string{{yesNo(value)}}})
+		}
+	}
+	for key, keyword := range map[string]string{
+		"ssh.allow users":  "AllowUsers",
+		"ssh.allow groups": "AllowGroups",
+	} {
+		if names, ok := profile.GetList(key); ok {
+			d := directive{Keyword: keyword}
+			if len(names) > 0 {
+				d.Lines = [][]string{quoteArgs(names)}
+			}
+			directives = append(directives, d)
+		}
+	}
+	for _, path := range profile.Paths() {
+		if name, ok := strings.CutPrefix(path, "ssh.subsystems."); ok {
+			command, _ := profile.Get(path)
+			directives = append(directives, directive{Keyword: "Subsystem", Name: name, Lines: [][]string{{name, command}}})
+		}
+	}
+
+	// Map iteration above is unordered; apply in a fixed order
+	sort.SliceStable(directives, func(i, j int) bool { return directives[i].Keyword < directives[j].Keyword })
+	return directives
+}
+
+// The port sshd ends up on, for the firewall rule
+func (s sshSettings) port() string {
+	if port, ok := s.profile.Get("ssh.port"); ok {
+		return port
+	}
+	return "22"
+}
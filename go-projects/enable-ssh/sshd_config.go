@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// One line of sshd_config, kept as written unless a managed directive replaces it
+type configLine struct {
+	raw     string
+	keyword string   // lowercased; empty for comments and blank lines
+	args    []string // what follows the keyword
+	match   bool     // inside a Match block
+}
+
+type sshdConfig struct {
+	lines   []configLine
+	newline string // \r\n if the file used it
+}
+
+// Parse sshd_config text. Keywords are case-insensitive and may be followed
+// by whitespace or '='; everything from the first Match to the end of the
+// file belongs to Match blocks.
+func parseSSHDConfig(text string) *sshdConfig {
+	config := &sshdConfig{newline: "\n"}
+	if strings.Contains(text, "\r\n") {
+		config.newline = "\r\n"
+	}
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return config
+	}
+
+	inMatch := false
+	for _, raw := range strings.Split(text, "\n") {
+		line := configLine{raw: raw}
+		trimmed := strings.TrimSpace(raw)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			end := strings.IndexAny(trimmed, " \t=")
+			if end < 0 {
+				end = len(trimmed)
+			}
+			keyword, rest := trimmed[:end], strings.TrimSpace(trimmed[end:])
+			line.keyword = strings.ToLower(keyword)
+			line.args = strings.Fields(strings.TrimPrefix(rest, "="))
+			if line.keyword == "match" {
+				inMatch = true
+			}
+		}
+		line.match = inMatch
+		config.lines = append(config.lines, line)
+	}
+	return config
+}
+
+func (c *sshdConfig) String() string {
+	if len(c.lines) == 0 {
+		return ""
+	}
+	raws := make([]string, len(c.lines))
+	for i, line := range c.lines {
+		raws[i] = line.raw
+	}
+	return strings.Join(raws, c.newline) + c.newline
+}
+
+// What a managed directive should be: one line per entry of Lines (several
+// for ListenAddress), or none to remove it. Name picks one Subsystem.
+type directive struct {
+	Keyword string
+	Name    string
+	Lines   [][]string
+}
+
+// Set makes the global part of the config say what d says. The first
+// existing line is replaced in place, keeping its indentation, and later
+// duplicates are dropped. A new directive goes after its commented-out
+// default (#Port 22), or else before the first Match block.
+func (c *sshdConfig) Set(d directive) {
+	keyword := strings.ToLower(d.Keyword)
+	rendered := make([]configLine, len(d.Lines))
+	for i, args := range d.Lines {
+		rendered[i] = configLine{raw: d.Keyword + " " + strings.Join(args, " "), keyword: keyword, args: args}
+	}
+
+	var kept []configLine
+	at := -1
+	for _, line := range c.lines {
+		if !line.match && line.keyword == keyword && c.selects(line, d) {
+			if at < 0 {
+				at = len(kept)
+				indent := line.raw[:len(line.raw)-len(strings.TrimLeft(line.raw, " \t"))]
+				for i := range rendered {
+					rendered[i].raw = indent + rendered[i].raw
+				}
+			}
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if at < 0 {
+		at = c.insertionPoint(kept, d)
+		// Directly before a Match block: keep a blank line between them
+		if len(rendered) > 0 && at < len(kept) && kept[at].keyword == "match" {
+			rendered = append(rendered, configLine{})
+		}
+	}
+	c.lines = append(kept[:at:at], append(rendered, kept[at:]...)...)
+}
+
+// Whether line is the one d manages (for Subsystem, the same subsystem)
+func (c *sshdConfig) selects(line configLine, d directive) bool {
+	if d.Name == "" {
+		return true
+	}
+	return len(line.args) > 0 && strings.EqualFold(line.args[0], d.Name)
+}
+
+func (c *sshdConfig) insertionPoint(lines []configLine, d directive) int {
+	commented := regexp.MustCompile(`(?i)^#\s*` + regexp.QuoteMeta(d.Keyword) + `\b`)
+	if d.Name != "" {
+		commented = regexp.MustCompile(`(?i)^#\s*` + regexp.QuoteMeta(d.Keyword) + `\s+` + regexp.QuoteMeta(d.Name) + `\b`)
+	}
+	after := -1
+	for i, line := range lines {
+		if !line.match && commented.MatchString(strings.TrimSpace(line.raw)) {
+			after = i
+		}
+	}
+	if after >= 0 {
+		return after + 1
+	}
+
+	for i, line := range lines {
+		if line.keyword != "match" {
+			continue
+		}
+		// Keep the comments that introduce the Match block with it
+		for i > 0 && lines[i-1].keyword == "" && strings.TrimSpace(lines[i-1].raw) != "" {
+			i--
+		}
+		return i
+	}
+	return len(lines)
+}
+
+// The first file of the AuthorizedKeysFile in the Match <criterion> <value>
+// block, or of the global one when criterion is empty; "" if not set there
+func (c *sshdConfig) authorizedKeysFile(criterion, value string) string {
+	inBlock := criterion == ""
+	for _, line := range c.lines {
+		if line.keyword == "match" {
+			inBlock = false
+			if criterion != "" && len(line.args) == 2 && strings.EqualFold(line.args[0], criterion) {
+				for _, v := range strings.Split(line.args[1], ",") {
+					inBlock = inBlock || strings.EqualFold(v, value)
+				}
+			}
+			continue
+		}
+		if inBlock && line.keyword == "authorizedkeysfile" && len(line.args) > 0 {
+			return strings.Trim(line.args[0], `"`)
+		}
+	}
+	return ""
+}
+
+// Where OpenSSH for Windows keeps its server config
+func defaultSSHDConfigPath() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, "ssh", "sshd_config")
+}
+
+// Render the directives into the sshd_config at path. The new text is tested
+// with sshd -t when sshd can be found. The first time the file changes the
+// original is kept as .bak (empty if there was no file); later changes leave
+// that backup alone. Reports whether the file changed.
+func writeSSHDConfig(path string, directives []directive) (bool, error) {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	config := parseSSHDConfig(string(existing))
+	for _, d := range directives {
+		config.Set(d)
+	}
+	rendered := config.String()
+	if rendered == string(existing) {
+		return false, nil
+	}
+
+	if err := testSSHDConfig(rendered); err != nil {
+		return false, err
+	}
+	// Back up only the file as it was before enable-ssh first changed it
+	backupPath := path + ".bak"
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		if err := os.WriteFile(backupPath, existing, 0644); err != nil {
+			return false, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		if len(existing) > 0 {
+			log.Printf("🔁 Existing file backed up: %s → %s", path, backupPath)
+		} else {
+			log.Printf("ℹ️ %s is new; the empty %s records that", path, backupPath)
+		}
+	}
+	if err := writeAtomic(path, []byte(rendered), 0644); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Atomic write: temp file -> rename, so sshd never reads half a config
+func writeAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Let sshd check the rendered config before it replaces the real one
+func testSSHDConfig(text string) error {
+	sshd, err := exec.LookPath("sshd")
+	if err != nil {
+		sshd = filepath.Join(os.Getenv("SystemRoot"), "System32", "OpenSSH", "sshd.exe")
+		if _, err := os.Stat(sshd); err != nil {
+			log.Println("ℹ️ sshd not found; skipping the sshd -t config test")
+			return nil
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "sshd_config-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	if out, err := exec.Command(sshd, "-t", "-f", tmp.Name()).CombinedOutput(); err != nil {
+		return fmt.Errorf("sshd rejected the new config (%v): %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Put back the sshd_config as it was before writeSSHDConfig first changed
+// it, removing it if there was none. The .bak is left in place, so running
+// this again finds nothing to do.
+func restoreSSHDConfig(path string) error {
+	backupPath := path + ".bak"
+	backup, err := os.ReadFile(backupPath)
+	if os.IsNotExist(err) {
+		log.Printf("ℹ️ No %s to restore", backupPath)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if len(backup) == 0 {
+		// enable-ssh created the file
+		if os.IsNotExist(err) {
+			log.Printf("✔️ %s already removed; there was none before enable-ssh", path)
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Printf("↩️ Removed %s, which did not exist before enable-ssh", path)
+		return nil
+	}
+	if string(existing) == string(backup) {
+		log.Printf("✔️ %s already matches %s", path, backupPath)
+		return nil
+	}
+	if err := writeAtomic(path, backup, 0644); err != nil {
+		return err
+	}
+	log.Printf("↩️ Restored %s from %s", path, backupPath)
+	return nil
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Exit codes, one per class of error, so scripts can tell them apart
+const (
+	exitOK       = 0
+	exitFailure  = 1 // registry, file or journal I/O
+	exitUsage    = 2 // bad command line
+	exitNotFound = 3 // path, entry or journal id does not exist
+	exitDenied   = 4 // needs elevation or access was refused
+	exitConflict = 5 // the variable changed in a way the command cannot reconcile
+)
+
+type codedError struct {
+	code int
+	err  error
+}
+
+func (e *codedError) Error() string { return e.err.Error() }
+func (e *codedError) Unwrap() error { return e.err }
+
+func withCode(code int, err error) error {
+	return &codedError{code: code, err: err}
+}
+
+func exitCodeOf(err error) int {
+	var coded *codedError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, os.ErrPermission):
+		return exitDenied
+	case errors.Is(err, os.ErrNotExist):
+		return exitNotFound
+	}
+	return exitFailure
+}
+
+// What positional arguments complete to in the generated shell scripts
+type argKind string
+
+const (
+	argNone      argKind = ""
+	argDirectory argKind = "directory"
+	argPath      argKind = "path" // files are accepted too
+	argVariable  argKind = "variable"
+	argShell     argKind = "shell"
+	argCommand   argKind = "command"
+)
+
+type runFunc func(ctx *cliContext, args []string) error
+
+// A subcommand. setup registers the command's own flags and returns the
+// function that runs it once they are parsed.
+type subcommand struct {
+	name    string
+	args    string // positional synopsis, e.g. "<path>..."
+	summary string
+	minArgs int
+	maxArgs int // -1 for any number
+	kind    argKind
+	setup   func(fs *flag.FlagSet) runFunc
+}
+
+// Options accepted before or after the command name
+type globalOptions struct {
+	scope   string
+	json    bool
+	envFile string
+}
+
+func (o *globalOptions) register(fs *flag.FlagSet) {
+	// Defaults are the current values so a subcommand's flag set does not reset what the root parsed
+	fs.StringVar(&o.scope, "scope", o.scope, "Environment to use: machine, user or process (process changes last only as long as this command)")
+	fs.BoolVar(&o.json, "json", o.json, "Write results as JSON")
+	fs.StringVar(&o.envFile, "env-file", o.envFile, "JSON file to use instead of the registry (also "+envFileVariable+")")
+}
+
+// What the command is running against; the store is opened on first use
+type cliContext struct {
+	options *globalOptions
+	scope   envScope
+	out     *output
+	journal string
+	store   *journaledStore
+}
+
+func (c *cliContext) openStore() (*journaledStore, error) {
+	if c.store != nil {
+		return c.store, nil
+	}
+	inner, err := openStore(c.options.envFile)
+	if err != nil {
+		return nil, err
+	}
+	c.store = newJournaledStore(inner, c.journal)
+	return c.store, nil
+}
+
+// Collects results for --json, or prints them as they come
+type output struct {
+	json     bool
+	Command  string   `json:"command"`
+	Scope    envScope `json:"scope,omitempty"`
+	Results  []any    `json:"results"`
+	Errors   []string `json:"errors,omitempty"`
+	ExitCode int      `json:"exitCode"`
+}
+
+func (o *output) add(result any, text string) {
+	if o.json {
+		o.Results = append(o.Results, result)
+		return
+	}
+	fmt.Println(text)
+}
+
+func (o *output) finish(err error) int {
+	o.ExitCode = exitCodeOf(err)
+	if err != nil {
+		o.Errors = strings.Split(err.Error(), "\n")
+	}
+	if o.json {
+		if o.Results == nil {
+			o.Results = []any{}
+		}
+		data, _ := json.MarshalIndent(o, "", "  ")
+		fmt.Println(string(data))
+		return o.ExitCode
+	}
+	for _, line := range o.Errors {
+		fmt.Println("❌", line)
+	}
+	return o.ExitCode
+}
+
+func findSubcommand(name string) *subcommand {
+	for _, cmd := range subcommands {
+		if cmd.name == strings.ToLower(name) {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func newFlagSet(name string, options *globalOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	options.register(fs)
+	return fs
+}
+
+// Like fs.Parse, but flags may also follow positional arguments
+// (go-command-line move Path C:\Tools --top)
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		if args[0] == "--" {
+			return append(positional, args[1:]...), nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func printRootUsage() {
+	fmt.Println("Usage: go-command-line [--scope machine|user|process] [--json] [--env-file <file>] <command> [flags] [arguments]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range subcommands {
+		fmt.Printf("  %-24s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Println()
+	fmt.Println("Run 'go-command-line help <command>' for the flags of a command.")
+	fmt.Println("Machine scope is the default and needs an elevated prompt to make changes.")
+	fmt.Println("Process scope is this command's own environment: changes to it do not outlive the command.")
+	fmt.Println()
+	fmt.Println("Exit codes: 0 ok, 1 failure, 2 usage, 3 not found, 4 access denied, 5 conflict")
+}
+
+func printCommandUsage(cmd *subcommand) {
+	fs := newFlagSet(cmd.name, &globalOptions{scope: string(scopeMachine)})
+	cmd.setup(fs)
+	fmt.Printf("Usage: go-command-line %s [flags] %s\n\n", cmd.name, cmd.args)
+	fmt.Println(cmd.summary)
+	fmt.Println()
+	fmt.Println("Flags:")
+	fs.SetOutput(os.Stdout)
+	fs.PrintDefaults()
+}
+
+// Parse the command line, run the command and return the exit code
+func run(args []string) int {
+	options := &globalOptions{scope: string(scopeMachine)}
+	root := newFlagSet("go-command-line", options)
+	help := root.Bool("help", false, "Show help")
+	if err := root.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printRootUsage()
+			return exitOK
+		}
+		fmt.Println("❌", err)
+		return exitUsage
+	}
+	if *help || root.NArg() == 0 {
+		printRootUsage()
+		if *help {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	cmd := findSubcommand(root.Arg(0))
+	if cmd == nil {
+		fmt.Println("❌ Unsupported command:", root.Arg(0))
+		printRootUsage()
+		return exitUsage
+	}
+
+	fs := newFlagSet(cmd.name, options)
+	runCommand := cmd.setup(fs)
+	positional, err := parseInterspersed(fs, root.Args()[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		printCommandUsage(cmd)
+		return exitOK
+	}
+
+	out := &output{json: options.json, Command: cmd.name}
+	if err == nil && (len(positional) < cmd.minArgs || (cmd.maxArgs >= 0 && len(positional) > cmd.maxArgs)) {
+		err = withCode(exitUsage, fmt.Errorf("usage: go-command-line %s [flags] %s", cmd.name, cmd.args))
+	} else if err != nil {
+		err = withCode(exitUsage, err)
+	}
+	if err != nil {
+		return out.finish(err)
+	}
+
+	scope, err := parseScope(options.scope)
+	if err != nil {
+		return out.finish(err)
+	}
+	out.Scope = scope
+
+	journal, err := journalPath()
+	if err != nil {
+		return out.finish(fmt.Errorf("cannot locate the change journal: %w", err))
+	}
+
+	ctx := &cliContext{options: options, scope: scope, out: out, journal: journal}
+	return out.finish(runCommand(ctx, positional))
+}
+
+// Flags of a command as the shells should offer them
+func completionFlags(cmd *subcommand) []string {
+	fs := newFlagSet(cmd.name, &globalOptions{})
+	cmd.setup(fs)
+	var flags []string
+	fs.VisitAll(func(f *flag.Flag) { flags = append(flags, "--"+f.Name) })
+	sort.Strings(flags)
+	return append(flags, "--help")
+}
+
+func bashCompletion() string {
+	var b strings.Builder
+	var names []string
+	for _, cmd := range subcommands {
+		names = append(names, cmd.name)
+	}
+
+	b.WriteString("# bash completion for go-command-line\n")
+	b.WriteString("# Load with: source <(go-command-line completion bash)\n")
+	b.WriteString("_go_command_line() {\n")
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	b.WriteString("    local cmd=\"\" flags=\"\" kind=\"\" i\n")
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        case \"${COMP_WORDS[i]}\" in\n")
+	b.WriteString("            --scope|-scope|--env-file|-env-file) ((i++)) ;;\n")
+	b.WriteString("            -*) ;;\n")
+	b.WriteString("            *) cmd=\"${COMP_WORDS[i]}\"; break ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n\n")
+	b.WriteString("    case \"$prev\" in\n")
+	b.WriteString("        --scope|-scope) COMPREPLY=($(compgen -W \"machine user process\" -- \"$cur\")); return ;;\n")
+	b.WriteString("        --env-file|-env-file) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n")
+	b.WriteString("    esac\n\n")
+	b.WriteString("    if [[ -z \"$cmd\" ]]; then\n")
+	fmt.Fprintf(&b, "        COMPREPLY=($(compgen -W \"%s --scope --json --env-file --help\" -- \"$cur\"))\n", strings.Join(names, " "))
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n\n")
+	b.WriteString("    case \"${cmd,,}\" in\n")
+	for _, cmd := range subcommands {
+		fmt.Fprintf(&b, "        %s) flags=\"%s\"; kind=\"%s\" ;;\n", cmd.name, strings.Join(completionFlags(cmd), " "), cmd.kind)
+	}
+	b.WriteString("    esac\n\n")
+	b.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	b.WriteString("        COMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	b.WriteString("        return\n")
+	b.WriteString("    fi\n")
+	b.WriteString("    case \"$kind\" in\n")
+	b.WriteString("        directory) COMPREPLY=($(compgen -d -- \"$cur\")) ;;\n")
+	b.WriteString("        path) COMPREPLY=($(compgen -f -- \"$cur\")) ;;\n")
+	b.WriteString("        variable) COMPREPLY=($(compgen -W \"Path PSModulePath PATHEXT\" -- \"$cur\")) ;;\n")
+	b.WriteString("        shell) COMPREPLY=($(compgen -W \"bash powershell\" -- \"$cur\")) ;;\n")
+	fmt.Fprintf(&b, "        command) COMPREPLY=($(compgen -W \"%s\" -- \"$cur\")) ;;\n", strings.Join(names, " "))
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	b.WriteString("complete -o filenames -F _go_command_line go-command-line go-command-line.exe\n")
+	return b.String()
+}
+
+func powershellCompletion() string {
+	var b strings.Builder
+	var names []string
+	for _, cmd := range subcommands {
+		names = append(names, "'"+cmd.name+"'")
+	}
+
+	b.WriteString("# PowerShell completion for go-command-line\n")
+	b.WriteString("# Load with: go-command-line completion powershell | Out-String | Invoke-Expression\n")
+	b.WriteString("Register-ArgumentCompleter -Native -CommandName 'go-command-line', 'go-command-line.exe' -ScriptBlock {\n")
+	b.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n\n")
+	b.WriteString("    $commands = @{\n")
+	for _, cmd := range subcommands {
+		var flags []string
+		for _, f := range completionFlags(cmd) {
+			flags = append(flags, "'"+f+"'")
+		}
+		fmt.Fprintf(&b, "        '%s' = @{ Flags = @(%s); Kind = '%s' }\n", cmd.name, strings.Join(flags, ", "), cmd.kind)
+	}
+	b.WriteString("    }\n")
+	fmt.Fprintf(&b, "    $names = @(%s)\n\n", strings.Join(names, ", "))
+	b.WriteString(`    $previous = @($commandAst.CommandElements | Select-Object -Skip 1 |
+        Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.Extent.Text })
+    $command = $null
+    for ($i = 0; $i -lt $previous.Count; $i++) {
+        $word = $previous[$i]
+        if ($word -in '--scope', '-scope', '--env-file', '-env-file') { $i++; continue }
+        if ($word.StartsWith('-')) { continue }
+        $command = $word.ToLower()
+        break
+    }
+    $last = if ($previous.Count -gt 0) { $previous[-1] } else { '' }
+
+    $candidates = @()
+    $kind = ''
+    if ($last -in '--scope', '-scope') {
+        $candidates = 'machine', 'user', 'process'
+    } elseif ($last -in '--env-file', '-env-file') {
+        $kind = 'path'
+    } elseif (-not $command) {
+        $candidates = $names + @('--scope', '--json', '--env-file', '--help')
+    } elseif ($commands.ContainsKey($command)) {
+        if ($wordToComplete.StartsWith('-')) {
+            $candidates = $commands[$command].Flags
+        } else {
+            $kind = $commands[$command].Kind
+        }
+    }
+
+    switch ($kind) {
+        'directory' { $candidates = Get-ChildItem -Path "$wordToComplete*" -Directory -ErrorAction SilentlyContinue | ForEach-Object FullName }
+        'path'      { $candidates = Get-ChildItem -Path "$wordToComplete*" -ErrorAction SilentlyContinue | ForEach-Object FullName }
+        'variable'  { $candidates = 'Path', 'PSModulePath', 'PATHEXT' }
+        'shell'     { $candidates = 'bash', 'powershell' }
+        'command'   { $candidates = $names }
+    }
+
+    $candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+        $text = if ($_ -match '\s') { "'$_'" } else { $_ }
+        [System.Management.Automation.CompletionResult]::new($text, $_, 'ParameterValue', $_)
+    }
+}
+`)
+	return b.String()
+}
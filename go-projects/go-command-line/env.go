@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Which environment a variable lives in, as in [EnvironmentVariableTarget]
+type envScope string
+
+const (
+	scopeMachine envScope = "machine"
+	scopeUser    envScope = "user"
+	scopeProcess envScope = "process"
+)
+
+func parseScope(s string) (envScope, error) {
+	switch scope := envScope(strings.ToLower(strings.TrimSpace(s))); scope {
+	case scopeMachine, scopeUser, scopeProcess:
+		return scope, nil
+	}
+	return "", withCode(exitUsage, fmt.Errorf("unknown scope '%s' (use machine, user or process)", s))
+}
+
+// Word used in messages, e.g. "system PATH"
+func (s envScope) label() string {
+	if s == scopeMachine {
+		return "system"
+	}
+	return string(s)
+}
+
+// Where environment variables are read from and written to. On Windows this
+// is the registry; a JSON file can stand in for it when trying things out.
+type envStore interface {
+	Get(scope envScope, name string) (string, error)
+	Set(scope envScope, name, value string) error
+	// CheckWritable reports why scope cannot be changed (e.g. machine scope without elevation)
+	CheckWritable(scope envScope) error
+	// Broadcast tells running programs (Explorer, new shells) that the environment changed
+	Broadcast() error
+}
+
+// Set GO_COMMAND_LINE_ENV_FILE (or pass --env-file) to use a JSON file instead of the registry
+const envFileVariable = "GO_COMMAND_LINE_ENV_FILE"
+
+// envFile (the --env-file flag) takes precedence over the environment variable
+func openStore(envFile string) (envStore, error) {
+	if envFile == "" {
+		envFile = os.Getenv(envFileVariable)
+	}
+	if envFile != "" {
+		return &fileStore{path: envFile}, nil
+	}
+	return openSystemStore()
+}
+
+// List-valued variables such as PATH and PSModulePath are ';'-separated on Windows
+const listSeparator = ";"
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, listSeparator)
+}
+
+func joinList(entries []string) string {
+	return strings.Join(entries, listSeparator)
+}
+
+// Same as PowerShell's TrimEnd('\'), also trimming '/' so either style compares equal
+func trimEntry(entry string) string {
+	if trimmed := strings.TrimRight(entry, `\/`); trimmed != "" {
+		return trimmed
+	}
+	return entry
+}
+
+func sameEntry(a, b string) bool {
+	return strings.EqualFold(trimEntry(a), trimEntry(b))
+}
+
+// Resolve a path the way the PowerShell functions did: make it absolute,
+// use the parent directory if it names a file (when fileToParent is set)
+// and drop trailing backslashes. mustExist mirrors Resolve-Path -ErrorAction Stop.
+func resolveEntry(path string, fileToParent, mustExist bool) (string, error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		return "", withCode(exitUsage, errors.New("path is empty"))
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	switch {
+	case err == nil:
+		if fileToParent && !info.IsDir() {
+			abs = filepath.Dir(abs)
+		}
+	case mustExist:
+		return "", withCode(exitNotFound, fmt.Errorf("cannot find path '%s' because it does not exist", path))
+	}
+	return trimEntry(abs), nil
+}
+
+// Read a list variable, let edit change the entries and write the result back
+// (and broadcast) only if edit reports a change. Process scope is this
+// command's own environment, so there is no one to tell.
+func updateList(store envStore, scope envScope, name string, edit func([]string) ([]string, bool)) (bool, error) {
+	current, err := store.Get(scope, name)
+	if err != nil {
+		return false, err
+	}
+	entries, changed := edit(splitList(current))
+	if !changed {
+		return false, nil
+	}
+	if err := store.CheckWritable(scope); err != nil {
+		return false, err
+	}
+	if err := store.Set(scope, name, joinList(entries)); err != nil {
+		return false, err
+	}
+	if scope == scopeProcess {
+		return true, nil
+	}
+	return true, store.Broadcast()
+}
+
+func indexOfEntry(entries []string, entry string) int {
+	for i, e := range entries {
+		if sameEntry(e, entry) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Add entry to a list variable, at the top or the bottom. Reports false if already present.
+func addToList(store envStore, scope envScope, name, entry string, top bool) (bool, error) {
+	return updateList(store, scope, name, func(entries []string) ([]string, bool) {
+		if indexOfEntry(entries, entry) >= 0 {
+			return entries, false
+		}
+		if top {
+			return append([]string{entry}, entries...), true
+		}
+		return append(entries, entry), true
+	})
+}
+
+// Remove every occurrence of entry from a list variable. Reports false if it was not there.
+func removeFromList(store envStore, scope envScope, name, entry string) (bool, error) {
+	return updateList(store, scope, name, func(entries []string) ([]string, bool) {
+		var kept []string
+		for _, e := range entries {
+			if !sameEntry(e, entry) {
+				kept = append(kept, e)
+			}
+		}
+		return kept, len(kept) != len(entries)
+	})
+}
+
+// Move an existing entry to the top or bottom. Reports an error if it is not in the list.
+func moveInList(store envStore, scope envScope, name, entry string, top bool) (bool, error) {
+	found := false
+	changed, err := updateList(store, scope, name, func(entries []string) ([]string, bool) {
+		i := indexOfEntry(entries, entry)
+		if i < 0 {
+			return entries, false
+		}
+		found = true
+		moved := entries[i]
+		rest := append(append([]string{}, entries[:i]...), entries[i+1:]...)
+		if top {
+			rest = append([]string{moved}, rest...)
+		} else {
+			rest = append(rest, moved)
+		}
+		return rest, strings.Join(rest, listSeparator) != strings.Join(entries, listSeparator)
+	})
+	if err == nil && !found {
+		return false, withCode(exitNotFound, fmt.Errorf("'%s' is not in %s", entry, name))
+	}
+	return changed, err
+}
+
+// Drop empty entries and later duplicates, keeping the first occurrence. Returns what was removed.
+func dedupeList(store envStore, scope envScope, name string) ([]string, error) {
+	var removed []string
+	_, err := updateList(store, scope, name, func(entries []string) ([]string, bool) {
+		var kept []string
+		for _, e := range entries {
+			if strings.TrimSpace(e) == "" || indexOfEntry(kept, e) >= 0 {
+				removed = append(removed, e)
+				continue
+			}
+			kept = append(kept, e)
+		}
+		return kept, len(removed) > 0
+	})
+	return removed, err
+}
+
+// Drop entries whose directories do not exist. Returns what was removed.
+func pruneMissing(store envStore, scope envScope, name string) ([]string, error) {
+	var removed []string
+	_, err := updateList(store, scope, name, func(entries []string) ([]string, bool) {
+		var kept []string
+		for _, e := range entries {
+			if !entryExists(e) {
+				removed = append(removed, e)
+				continue
+			}
+			kept = append(kept, e)
+		}
+		return kept, len(removed) > 0
+	})
+	return removed, err
+}
+
+// Whether a list entry names an existing directory, expanding %VAR% references first
+func entryExists(entry string) bool {
+	if strings.TrimSpace(entry) == "" {
+		return false
+	}
+	info, err := os.Stat(expandWindowsVars(entry))
+	return err == nil && info.IsDir()
+}
+
+// Expand %NAME% the way REG_EXPAND_SZ values are expanded; unknown names are left as is
+func expandWindowsVars(s string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(s, '%')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start+1:], '%')
+		if end < 0 {
+			break
+		}
+		name := s[start+1 : start+1+end]
+		if value, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(s[:start] + value)
+		} else {
+			b.WriteString(s[:start+2+end])
+		}
+		s = s[start+2+end:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// JSON file of scope → variable name → value, used instead of the registry
+// when GO_COMMAND_LINE_ENV_FILE is set. Names are case-insensitive as on Windows.
+type fileStore struct {
+	path string
+}
+
+func (f *fileStore) load() (map[envScope]map[string]string, error) {
+	vars := make(map[envScope]map[string]string)
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return vars, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &vars); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.path, err)
+	}
+	return vars, nil
+}
+
+func (f *fileStore) Get(scope envScope, name string) (string, error) {
+	vars, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	for k, v := range vars[scope] {
+		if strings.EqualFold(k, name) {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+func (f *fileStore) Set(scope envScope, name, value string) error {
+	vars, err := f.load()
+	if err != nil {
+		return err
+	}
+	if vars[scope] == nil {
+		vars[scope] = make(map[string]string)
+	}
+	for k := range vars[scope] {
+		if strings.EqualFold(k, name) {
+			name = k
+		}
+	}
+	vars[scope][name] = value
+	data, err := json.MarshalIndent(vars, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, data, 0o644)
+}
+
+func (f *fileStore) CheckWritable(scope envScope) error {
+	return nil
+}
+
+func (f *fileStore) Broadcast() error {
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// There is no system-wide environment store outside Windows; use GO_COMMAND_LINE_ENV_FILE
+func openSystemStore() (envStore, error) {
+	return nil, withCode(exitUsage, errors.New("the registry environment is only available on Windows; set "+envFileVariable+" or pass --env-file to use a file instead"))
+}
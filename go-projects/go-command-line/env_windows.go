@@ -0,0 +1,105 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+// Registry locations behind [Environment]::SetEnvironmentVariable(..., "Machine"/"User")
+const (
+	machineEnvironmentKey = `SYSTEM\CurrentControlSet\Control\Session Manager\Environment`
+	userEnvironmentKey    = `Environment`
+)
+
+type registryStore struct{}
+
+func openSystemStore() (envStore, error) {
+	return registryStore{}, nil
+}
+
+func openEnvironmentKey(scope envScope, access uint32) (registry.Key, error) {
+	if scope == scopeMachine {
+		return registry.OpenKey(registry.LOCAL_MACHINE, machineEnvironmentKey, access)
+	}
+	return registry.OpenKey(registry.CURRENT_USER, userEnvironmentKey, access)
+}
+
+// Values are read unexpanded so entries like %SystemRoot%\system32 survive a rewrite
+func (registryStore) Get(scope envScope, name string) (string, error) {
+	if scope == scopeProcess {
+		return os.Getenv(name), nil
+	}
+	key, err := openEnvironmentKey(scope, registry.QUERY_VALUE)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s environment: %w", scope, err)
+	}
+	defer key.Close()
+
+	value, _, err := key.GetStringValue(name)
+	if errors.Is(err, registry.ErrNotExist) {
+		return "", nil
+	}
+	return value, err
+}
+
+// Keep REG_EXPAND_SZ for values that were already expandable or contain %VAR% references
+func (registryStore) Set(scope envScope, name, value string) error {
+	if scope == scopeProcess {
+		return os.Setenv(name, value)
+	}
+	key, err := openEnvironmentKey(scope, registry.QUERY_VALUE|registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open %s environment for writing: %w", scope, err)
+	}
+	defer key.Close()
+
+	_, valueType, err := key.GetValue(name, nil)
+	if valueType == registry.EXPAND_SZ || (err != nil && strings.Contains(value, "%")) {
+		return key.SetExpandStringValue(name, value)
+	}
+	return key.SetStringValue(name, value)
+}
+
+func (registryStore) CheckWritable(scope envScope) error {
+	if scope == scopeMachine && !windows.GetCurrentProcessToken().IsElevated() {
+		return withCode(exitDenied, errors.New("machine scope changes the environment of every user and requires an elevated prompt; run as administrator or use --scope user"))
+	}
+	return nil
+}
+
+var procSendMessageTimeoutW = windows.NewLazySystemDLL("user32.dll").NewProc("SendMessageTimeoutW")
+
+// Native equivalent of Broadcast-EnvChange
+func (registryStore) Broadcast() error {
+	const (
+		hwndBroadcast   = 0xffff
+		wmSettingChange = 0x001A
+		smtoAbortIfHung = 0x0002
+	)
+	environment, err := windows.UTF16PtrFromString("Environment")
+	if err != nil {
+		return err
+	}
+	var result uintptr
+	ret, _, callErr := procSendMessageTimeoutW.Call(
+		hwndBroadcast,
+		wmSettingChange,
+		0,
+		uintptr(unsafe.Pointer(environment)),
+		smtoAbortIfHung,
+		5000,
+		uintptr(unsafe.Pointer(&result)),
+	)
+	if ret == 0 {
+		return fmt.Errorf("WM_SETTINGCHANGE broadcast failed: %w", callErr)
+	}
+	return nil
+}
@@ -1,3 +1,5 @@
 module go-command-line
 
 go 1.24.4
+
+require golang.org/x/sys v0.33.0
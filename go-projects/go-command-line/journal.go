@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Set GO_COMMAND_LINE_JOURNAL to keep the journal somewhere other than the user config folder
+const journalVariable = "GO_COMMAND_LINE_JOURNAL"
+
+// One change to an environment variable. The journal is JSON lines and is
+// only ever appended to, so a bad edit to the machine PATH can always be traced
+// back to the value it replaced.
+type journalEntry struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Scope    envScope  `json:"scope"`
+	Variable string    `json:"variable"`
+	Old      string    `json:"old"`
+	New      string    `json:"new"`
+	Command  string    `json:"command"`
+	User     string    `json:"user"`
+	Host     string    `json:"host"`
+	Undoes   int       `json:"undoes,omitempty"`
+}
+
+func journalPath() (string, error) {
+	if path := os.Getenv(journalVariable); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "go-command-line", "journal.jsonl"), nil
+}
+
+func readJournal(path string) ([]journalEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func appendJournal(path string, e journalEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Wraps a store so that every Set is written to the journal
+type journaledStore struct {
+	envStore
+	path    string
+	command string
+	undoes  int // set while an undo is being applied
+}
+
+func newJournaledStore(store envStore, path string) *journaledStore {
+	command := filepath.Base(os.Args[0])
+	if len(os.Args) > 1 {
+		command += " " + strings.Join(os.Args[1:], " ")
+	}
+	return &journaledStore{envStore: store, path: path, command: command}
+}
+
+// Set journals the change before making it, so that nothing is changed that
+// cannot be undone. If the change then fails, undoing its entry finds the
+// variable as it was and leaves it so.
+func (j *journaledStore) Set(scope envScope, name, value string) error {
+	old, err := j.envStore.Get(scope, name)
+	if err != nil {
+		return err
+	}
+
+	entries, err := readJournal(j.path)
+	if err != nil {
+		return fmt.Errorf("left %s unchanged: could not read the journal: %w", name, err)
+	}
+	e := journalEntry{
+		ID:       len(entries) + 1,
+		Time:     time.Now(),
+		Scope:    scope,
+		Variable: name,
+		Old:      old,
+		New:      value,
+		Command:  j.command,
+		Undoes:   j.undoes,
+	}
+	if len(entries) > 0 {
+		e.ID = entries[len(entries)-1].ID + 1
+	}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	e.Host, _ = os.Hostname()
+
+	if err := appendJournal(j.path, e); err != nil {
+		return fmt.Errorf("left %s unchanged: could not write the journal: %w", name, err)
+	}
+	return j.envStore.Set(scope, name, value)
+}
+
+// Entries present in b but not in a, counting duplicates
+func listDifference(a, b []string) []string {
+	remaining := append([]string{}, a...)
+	var diff []string
+	for _, e := range b {
+		if i := indexOfEntry(remaining, e); i >= 0 {
+			remaining = append(remaining[:i], remaining[i+1:]...)
+			continue
+		}
+		diff = append(diff, e)
+	}
+	return diff
+}
+
+// Undo a journal entry against the current value. If nothing touched the
+// variable since, the old value comes back exactly. Otherwise only the change
+// itself is reversed: entries it added are removed and entries it removed are
+// put back next to their old neighbours, so later additions survive.
+func undoEntries(e journalEntry, current []string) ([]string, error) {
+	oldEntries, newEntries := splitList(e.Old), splitList(e.New)
+	if joinList(current) == e.New {
+		return oldEntries, nil
+	}
+
+	added := listDifference(oldEntries, newEntries)
+	removed := listDifference(newEntries, oldEntries)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, withCode(exitConflict, fmt.Errorf("entry %d only reordered %s and the variable has changed since; use move instead", e.ID, e.Variable))
+	}
+
+	result := append([]string{}, current...)
+	for _, a := range added {
+		if i := indexOfEntry(result, a); i >= 0 {
+			result = append(result[:i], result[i+1:]...)
+		}
+	}
+	for _, r := range removed {
+		// Added back since, or never removed because the change failed
+		if indexOfEntry(result, r) >= 0 {
+			continue
+		}
+		at := 0
+		if i := indexOfEntry(oldEntries, r); i > 0 {
+			// After the closest earlier neighbour that is still there
+			for k := i - 1; k >= 0; k-- {
+				if j := indexOfEntry(result, oldEntries[k]); j >= 0 {
+					at = j + 1
+					break
+				}
+			}
+		}
+		result = append(result[:at], append([]string{r}, result[at:]...)...)
+	}
+	return result, nil
+}
+
+func findUndoTarget(entries []journalEntry, idArg string) (journalEntry, error) {
+	if idArg != "" {
+		id, err := strconv.Atoi(strings.TrimPrefix(idArg, "#"))
+		if err != nil {
+			return journalEntry{}, withCode(exitUsage, fmt.Errorf("'%s' is not a journal id", idArg))
+		}
+		for _, e := range entries {
+			if e.ID == id {
+				return e, nil
+			}
+		}
+		return journalEntry{}, withCode(exitNotFound, fmt.Errorf("there is no journal entry %d", id))
+	}
+
+	// Most recent change that is not an undo and has not been undone yet
+	undone := make(map[int]bool)
+	for _, e := range entries {
+		if e.Undoes != 0 {
+			undone[e.Undoes] = true
+		}
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Undoes == 0 && !undone[entries[i].ID] {
+			return entries[i], nil
+		}
+	}
+	return journalEntry{}, withCode(exitNotFound, errors.New("there is nothing to undo"))
+}
+
+func runUndo(ctx *cliContext, idArg string) error {
+	store, err := ctx.openStore()
+	if err != nil {
+		return err
+	}
+	entries, err := readJournal(store.path)
+	if err != nil {
+		return err
+	}
+	target, err := findUndoTarget(entries, idArg)
+	if err != nil {
+		return err
+	}
+
+	store.undoes = target.ID
+	defer func() { store.undoes = 0 }()
+
+	var undoErr error
+	changed, err := updateList(store, target.Scope, target.Variable, func(current []string) ([]string, bool) {
+		restored, err := undoEntries(target, current)
+		if err != nil {
+			undoErr = err
+			return current, false
+		}
+		return restored, joinList(restored) != joinList(current)
+	})
+	if undoErr != nil {
+		return undoErr
+	}
+	if err != nil {
+		return err
+	}
+
+	label := target.Scope.label() + " " + displayName(target.Variable)
+	if !changed {
+		ctx.out.add(target, fmt.Sprintf("Entry %d is already undone; %s is unchanged.", target.ID, label))
+		return nil
+	}
+	ctx.out.add(target, fmt.Sprintf("Undid entry %d (%s) on %s.", target.ID, target.Command, label))
+	return nil
+}
+
+// Print the journal, optionally only for one variable and only the last limit entries
+func runHistory(ctx *cliContext, variable string, limit int) error {
+	entries, err := readJournal(ctx.journal)
+	if err != nil {
+		return err
+	}
+	var shown []journalEntry
+	for _, e := range entries {
+		if variable == "" || strings.EqualFold(e.Variable, variable) {
+			shown = append(shown, e)
+		}
+	}
+	if limit > 0 && len(shown) > limit {
+		shown = shown[len(shown)-limit:]
+	}
+
+	for _, e := range shown {
+		lines := []string{fmt.Sprintf("#%d  %s  %s %s  %s@%s", e.ID, e.Time.Local().Format("2006-01-02 15:04:05"),
+			e.Scope.label(), displayName(e.Variable), e.User, e.Host)}
+		if e.Undoes != 0 {
+			lines = append(lines, fmt.Sprintf("      undo of #%d", e.Undoes))
+		} else {
+			lines = append(lines, "      "+e.Command)
+		}
+		oldEntries, newEntries := splitList(e.Old), splitList(e.New)
+		added, removed := listDifference(oldEntries, newEntries), listDifference(newEntries, oldEntries)
+		for _, a := range added {
+			lines = append(lines, "      + "+a)
+		}
+		for _, r := range removed {
+			lines = append(lines, "      - "+r)
+		}
+		if len(added) == 0 && len(removed) == 0 && e.Old != e.New {
+			lines = append(lines, "      ~ order changed")
+		}
+		ctx.out.add(e, strings.Join(lines, "\n"))
+	}
+	if len(shown) == 0 && !ctx.out.json {
+		fmt.Println("No changes recorded in", ctx.journal)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Undoing a remove must not put back an entry that was added again since
+func TestUndoRemoveAfterReAdd(t *testing.T) {
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, "env.json")
+	t.Setenv(envFileVariable, envFile)
+	t.Setenv(journalVariable, filepath.Join(dir, "journal.jsonl"))
+
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	for _, args := range [][]string{
+		{"add", "MYLIST", a},
+		{"add", "MYLIST", b},
+		{"remove", "MYLIST", a},
+		{"add", "MYLIST", a},
+		{"undo", "3"},
+	} {
+		if code := run(args); code != exitOK {
+			t.Fatalf("%v: exit code %d", args, code)
+		}
+	}
+
+	store := &fileStore{path: envFile}
+	got, err := store.Get(scopeMachine, "MYLIST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := joinList([]string{b, a}); got != want {
+		t.Errorf("MYLIST = %q, want %q", got, want)
+	}
+}
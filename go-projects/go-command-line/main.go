@@ -1,174 +1,363 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
-	"os/exec"
 	"strings"
 )
 
-var powershellFunctions = map[string]string{
-	"add-topath": `
-function Add-ToPath {
-    param ([string]$PathToAdd)
-    try {
-        $resolvedPath = Resolve-Path -Path $PathToAdd -ErrorAction Stop
-        $targetPath = if (Test-Path $resolvedPath.Path -PathType Leaf) {
-            Split-Path -Path $resolvedPath.Path -Parent
-        } else {
-            $resolvedPath.Path
-        }
-        $normalizedPath = $targetPath.TrimEnd('\')
-        $currentPath = [Environment]::GetEnvironmentVariable("Path", "Machine")
-        $pathEntries = $currentPath.Split(';') | ForEach-Object { $_.TrimEnd('\') }
-        if ($pathEntries -contains $normalizedPath) {
-            Write-Host "Path '$normalizedPath' is already in the system PATH."
-            return
-        }
-        $newPath = "$normalizedPath;$currentPath"
-        [Environment]::SetEnvironmentVariable("Path", $newPath, "Machine")
-        Write-Host "Path '$normalizedPath' added to the TOP of system PATH."
-        Broadcast-EnvChange
-    } catch {
-        Write-Error "Failed to add path: $_"
-    }
-}
-`,
-	"remove-frompath": `
-function Remove-FromPath {
-    param ([string]$PathToRemove)
-    try {
-        $resolvedPath = Resolve-Path -Path $PathToRemove -ErrorAction Stop
-        $targetPath = if (Test-Path $resolvedPath.Path -PathType Leaf) {
-            Split-Path -Path $resolvedPath.Path -Parent
-        } else {
-            $resolvedPath.Path
-        }
-        $normalizedPath = $targetPath.TrimEnd('\')
-        $currentPath = [Environment]::GetEnvironmentVariable("Path", "Machine")
-        $pathEntries = $currentPath.Split(';') | ForEach-Object { $_.TrimEnd('\') }
-        if ($pathEntries -notcontains $normalizedPath) {
-            Write-Host "Path '$normalizedPath' not found in system PATH."
-            return
-        }
-        $updatedPath = ($pathEntries | Where-Object { $_ -ne $normalizedPath }) -join ';'
-        [Environment]::SetEnvironmentVariable("Path", $updatedPath, "Machine")
-        Write-Host "Path '$normalizedPath' removed from system PATH."
-        Broadcast-EnvChange
-    } catch {
-        Write-Error "Failed to remove path: $_"
-    }
-}
-`,
-	"add-topsmodulepath": `
-function Add-ToPSModulePath {
-    param ([string]$Directory)
-    try {
-        $resolvedPath = (Resolve-Path -Path $Directory -ErrorAction Stop).Path.TrimEnd('\')
-        $currentSystemPath = [Environment]::GetEnvironmentVariable("PSModulePath", "Machine")
-        $pathEntries = $currentSystemPath -split ';' | ForEach-Object { $_.TrimEnd('\') }
-        if ($pathEntries -contains $resolvedPath) {
-            Write-Host "Path already in system PSModulePath: $resolvedPath"
-        } else {
-            $newPath = "$currentSystemPath;$resolvedPath"
-            [Environment]::SetEnvironmentVariable("PSModulePath", $newPath, "Machine")
-            Write-Host "Added to system PSModulePath: $resolvedPath"
-            Broadcast-EnvChange
-        }
-    } catch {
-        Write-Error "Failed to add to PSModulePath: $_"
-    }
-}
-`,
-	"remove-frompsmodulepath": `
-function Remove-FromPSModulePath {
-    param ([string]$Directory)
-    try {
-        $resolvedPath = (Resolve-Path -Path $Directory -ErrorAction Stop).Path.TrimEnd('\')
-        $currentSystemPath = [Environment]::GetEnvironmentVariable("PSModulePath", "Machine")
-        $pathEntries = $currentSystemPath -split ';' | ForEach-Object { $_.TrimEnd('\') }
-        if ($pathEntries -notcontains $resolvedPath) {
-            Write-Host "Path not found in system PSModulePath: $resolvedPath"
-            return
-        }
-        $updatedPath = ($pathEntries | Where-Object { $_ -ne $resolvedPath }) -join ';'
-        [Environment]::SetEnvironmentVariable("PSModulePath", $updatedPath, "Machine")
-        Write-Host "Removed from system PSModulePath: $resolvedPath"
-        Broadcast-EnvChange
-    } catch {
-        Write-Error "Failed to remove from PSModulePath: $_"
-    }
-}
-`,
-	"broadcast-envchange": `
-function Broadcast-EnvChange {
-    $signature = @"
-    [DllImport("user32.dll", SetLastError = true)]
-    public static extern IntPtr SendMessageTimeout(
-        IntPtr hWnd, uint Msg, UIntPtr wParam, string lParam,
-        uint fuFlags, uint uTimeout, out UIntPtr lpdwResult);
-"@
-    Add-Type -MemberDefinition $signature -Namespace Win32 -Name NativeMethods
-    $HWND_BROADCAST = [IntPtr]0xffff
-    $WM_SETTINGCHANGE = 0x001A
-    $SMTO_ABORTIFHUNG = 0x0002
-    $result = [UIntPtr]::Zero
-    [Win32.NativeMethods]::SendMessageTimeout(
-        $HWND_BROADCAST,
-        $WM_SETTINGCHANGE,
-        [UIntPtr]::Zero,
-        "Environment",
-        $SMTO_ABORTIFHUNG,
-        5000,
-        [ref]$result
-    ) | Out-Null
-}
-`,
+// How each command edits its list variable. These follow the PowerShell
+// functions they replace: Add-ToPath puts the directory at the TOP of PATH
+// and accepts a file (using its folder); Add-ToPSModulePath appends.
+type listCommand struct {
+	Variable     string
+	Remove       bool
+	Top          bool
+	FileToParent bool
+}
+
+// Variables whose entries are directories; entries of any other variable are
+// compared as plain text (e.g. PATHEXT)
+var pathVariables = map[string]string{
+	"path":         "Path",
+	"psmodulepath": "PSModulePath",
+}
+
+// What happened to one entry, for --json
+type entryResult struct {
+	Variable string `json:"variable"`
+	Entry    string `json:"entry"`
+	Action   string `json:"action"` // added, removed, moved, unchanged, present or absent
+	Position int    `json:"position,omitempty"`
+}
+
+type listedEntry struct {
+	Position int    `json:"position"`
+	Entry    string `json:"entry"`
+	Exists   *bool  `json:"exists,omitempty"` // only reported for directory lists
+}
+
+type listResult struct {
+	Variable string        `json:"variable"`
+	Entries  []listedEntry `json:"entries"`
+}
+
+var subcommands []*subcommand
+
+func init() {
+	subcommands = []*subcommand{
+		{name: "add-topath", args: "<path>...", summary: "Add directories (or the folders of files) to the top of PATH",
+			minArgs: 1, maxArgs: -1, kind: argPath, setup: func(fs *flag.FlagSet) runFunc {
+				bottom := fs.Bool("bottom", false, "Append instead of inserting at the top")
+				return func(ctx *cliContext, args []string) error {
+					return runListCommand(ctx, listCommand{Variable: "Path", Top: !*bottom, FileToParent: true}, args)
+				}
+			}},
+		{name: "remove-frompath", args: "<path>...", summary: "Remove directories from PATH",
+			minArgs: 1, maxArgs: -1, kind: argPath, setup: noFlags(func(ctx *cliContext, args []string) error {
+				return runListCommand(ctx, listCommand{Variable: "Path", Remove: true, FileToParent: true}, args)
+			})},
+		{name: "add-topsmodulepath", args: "<path>...", summary: "Append directories to PSModulePath",
+			minArgs: 1, maxArgs: -1, kind: argDirectory, setup: func(fs *flag.FlagSet) runFunc {
+				top := fs.Bool("top", false, "Insert at the top instead of appending")
+				return func(ctx *cliContext, args []string) error {
+					return runListCommand(ctx, listCommand{Variable: "PSModulePath", Top: *top}, args)
+				}
+			}},
+		{name: "remove-frompsmodulepath", args: "<path>...", summary: "Remove directories from PSModulePath",
+			minArgs: 1, maxArgs: -1, kind: argDirectory, setup: noFlags(func(ctx *cliContext, args []string) error {
+				return runListCommand(ctx, listCommand{Variable: "PSModulePath", Remove: true}, args)
+			})},
+		{name: "add", args: "<variable> <entry>...", summary: "Append entries to any ';'-separated variable",
+			minArgs: 2, maxArgs: -1, kind: argVariable, setup: func(fs *flag.FlagSet) runFunc {
+				top := fs.Bool("top", false, "Insert at the top instead of appending")
+				return func(ctx *cliContext, args []string) error {
+					return runListCommand(ctx, listCommand{Variable: canonicalVariable(args[0]), Top: *top}, args[1:])
+				}
+			}},
+		{name: "remove", args: "<variable> <entry>...", summary: "Remove entries from any ';'-separated variable",
+			minArgs: 2, maxArgs: -1, kind: argVariable, setup: noFlags(func(ctx *cliContext, args []string) error {
+				return runListCommand(ctx, listCommand{Variable: canonicalVariable(args[0]), Remove: true}, args[1:])
+			})},
+		{name: "list", args: "<variable>", summary: "Show the entries of a variable",
+			minArgs: 1, maxArgs: 1, kind: argVariable, setup: func(fs *flag.FlagSet) runFunc {
+				missing := fs.Bool("missing", false, "Only show directories that do not exist")
+				return func(ctx *cliContext, args []string) error {
+					return runList(ctx, canonicalVariable(args[0]), *missing)
+				}
+			}},
+		{name: "contains", args: "<variable> <entry>...", summary: "Check whether entries are present (exit code 3 if any is not)",
+			minArgs: 2, maxArgs: -1, kind: argVariable, setup: noFlags(func(ctx *cliContext, args []string) error {
+				return runContains(ctx, canonicalVariable(args[0]), args[1:])
+			})},
+		{name: "dedupe", args: "<variable>", summary: "Remove empty entries and duplicates, keeping the first occurrence",
+			minArgs: 1, maxArgs: 1, kind: argVariable, setup: noFlags(func(ctx *cliContext, args []string) error {
+				return runCleanup(ctx, canonicalVariable(args[0]), dedupeList)
+			})},
+		{name: "prune-missing", args: "<variable>", summary: "Remove directories that do not exist",
+			minArgs: 1, maxArgs: 1, kind: argVariable, setup: noFlags(func(ctx *cliContext, args []string) error {
+				return runCleanup(ctx, canonicalVariable(args[0]), pruneMissing)
+			})},
+		{name: "move", args: "<variable> <entry>", summary: "Move an entry to the top or bottom",
+			minArgs: 2, maxArgs: 2, kind: argVariable, setup: func(fs *flag.FlagSet) runFunc {
+				top := fs.Bool("top", false, "Move to the top")
+				bottom := fs.Bool("bottom", false, "Move to the bottom")
+				return func(ctx *cliContext, args []string) error {
+					if *top == *bottom {
+						return withCode(exitUsage, errors.New("move needs exactly one of --top or --bottom"))
+					}
+					return runMove(ctx, canonicalVariable(args[0]), args[1], *top)
+				}
+			}},
+		{name: "history", args: "[variable]", summary: "Show the journal of changes",
+			minArgs: 0, maxArgs: 1, kind: argVariable, setup: func(fs *flag.FlagSet) runFunc {
+				limit := fs.Int("limit", 0, "Only show the most recent N changes")
+				return func(ctx *cliContext, args []string) error {
+					return runHistory(ctx, strings.Join(args, ""), *limit)
+				}
+			}},
+		{name: "undo", args: "[id]", summary: "Reverse the latest change, or the journal entry with the given id",
+			minArgs: 0, maxArgs: 1, setup: noFlags(func(ctx *cliContext, args []string) error {
+				return runUndo(ctx, strings.Join(args, ""))
+			})},
+		{name: "completion", args: "<bash|powershell>", summary: "Print a shell completion script",
+			minArgs: 1, maxArgs: 1, kind: argShell, setup: noFlags(func(ctx *cliContext, args []string) error {
+				switch strings.ToLower(args[0]) {
+				case "bash":
+					fmt.Print(bashCompletion())
+				case "powershell", "pwsh":
+					fmt.Print(powershellCompletion())
+				default:
+					return withCode(exitUsage, fmt.Errorf("no completion for shell '%s' (use bash or powershell)", args[0]))
+				}
+				return nil
+			})},
+		{name: "help", args: "[command]", summary: "Show help for a command",
+			minArgs: 0, maxArgs: 1, kind: argCommand, setup: noFlags(func(ctx *cliContext, args []string) error {
+				if len(args) == 0 {
+					printRootUsage()
+					return nil
+				}
+				cmd := findSubcommand(args[0])
+				if cmd == nil {
+					return withCode(exitUsage, fmt.Errorf("unsupported command: %s", args[0]))
+				}
+				printCommandUsage(cmd)
+				return nil
+			})},
+	}
+}
+
+func noFlags(run runFunc) func(fs *flag.FlagSet) runFunc {
+	return func(*flag.FlagSet) runFunc { return run }
 }
 
 func main() {
-	if len(os.Args) != 3 {
-		fmt.Println("Usage:")
-		fmt.Println("  1234.exe add-toPath <path>")
-		fmt.Println("  1234.exe remove-fromPath <path>")
-		fmt.Println("  1234.exe add-toPSModulePath <path>")
-		fmt.Println("  1234.exe remove-fromPSModulePath <path>")
-		os.Exit(1)
+	os.Exit(run(os.Args[1:]))
+}
+
+// Add or remove each path in turn. A failure does not stop the remaining
+// paths; all errors are reported and the first one sets the exit code.
+func runListCommand(ctx *cliContext, cmd listCommand, pathArgs []string) error {
+	store, err := ctx.openStore()
+	if err != nil {
+		return err
+	}
+	label := ctx.scope.label() + " " + displayName(cmd.Variable)
+	verb := "add"
+	if cmd.Remove {
+		verb = "remove"
+	}
+
+	// Inserting at the top one by one reverses the order, so go backwards
+	order := pathArgs
+	if cmd.Top && !cmd.Remove {
+		order = make([]string, len(pathArgs))
+		for i, arg := range pathArgs {
+			order[len(pathArgs)-1-i] = arg
+		}
 	}
 
-	command := strings.ToLower(os.Args[1])
-	pathArg := os.Args[2]
+	var errs []error
+	for _, pathArg := range order {
+		// Removing does not require the directory to still exist
+		entry, err := variableEntry(cmd.Variable, pathArg, cmd.FileToParent, !cmd.Remove)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to %s path: %w", verb, err))
+			continue
+		}
+
+		if cmd.Remove {
+			changed, err := removeFromList(store, ctx.scope, cmd.Variable, entry)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove path: %w", err))
+				continue
+			}
+			if !changed {
+				ctx.out.add(entryResult{cmd.Variable, entry, "unchanged", 0}, fmt.Sprintf("Path '%s' not found in %s.", entry, label))
+				continue
+			}
+			ctx.out.add(entryResult{cmd.Variable, entry, "removed", 0}, fmt.Sprintf("Path '%s' removed from %s.", entry, label))
+			continue
+		}
 
-	scriptFunc, found := powershellFunctions[command]
-	if !found {
-		fmt.Println("❌ Unsupported command:", command)
-		os.Exit(1)
+		changed, err := addToList(store, ctx.scope, cmd.Variable, entry, cmd.Top)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to add path: %w", err))
+			continue
+		}
+		if !changed {
+			ctx.out.add(entryResult{cmd.Variable, entry, "unchanged", 0}, fmt.Sprintf("Path '%s' is already in the %s.", entry, label))
+			continue
+		}
+		position := "BOTTOM"
+		if cmd.Top {
+			position = "TOP"
+		}
+		ctx.out.add(entryResult{cmd.Variable, entry, "added", 0}, fmt.Sprintf("Path '%s' added to the %s of %s.", entry, position, label))
 	}
+	return errors.Join(errs...)
+}
 
-	fullScript := powershellFunctions["broadcast-envchange"] + scriptFunc + fmt.Sprintf("\n%s \"%s\"", getFunctionCall(command), pathArg)
+func runList(ctx *cliContext, variable string, missingOnly bool) error {
+	store, err := ctx.openStore()
+	if err != nil {
+		return err
+	}
+	value, err := store.Get(ctx.scope, variable)
+	if err != nil {
+		return err
+	}
 
-	cmd := exec.Command("powershell.exe", "-NoProfile", "-ExecutionPolicy", "Bypass", "-Command", fullScript)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	label := ctx.scope.label() + " " + displayName(variable)
+	_, isPath := pathVariables[strings.ToLower(variable)]
+	result := listResult{Variable: variable, Entries: []listedEntry{}}
+	var lines []string
+	for i, e := range splitList(value) {
+		listed := listedEntry{Position: i + 1, Entry: e}
+		note := ""
+		if isPath {
+			exists := entryExists(e)
+			listed.Exists = &exists
+			if !exists {
+				note = "  (missing)"
+			}
+			if missingOnly && exists {
+				continue
+			}
+		}
+		result.Entries = append(result.Entries, listed)
+		lines = append(lines, fmt.Sprintf("%3d  %s%s", i+1, e, note))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, fmt.Sprintf("%s has no entries to show.", label))
+	}
+	ctx.out.add(result, strings.Join(lines, "\n"))
+	return nil
+}
 
-	err := cmd.Run()
+func runContains(ctx *cliContext, variable string, args []string) error {
+	store, err := ctx.openStore()
 	if err != nil {
-		fmt.Println("❌ PowerShell execution failed:", err)
-		os.Exit(1)
+		return err
+	}
+	value, err := store.Get(ctx.scope, variable)
+	if err != nil {
+		return err
+	}
+	entries := splitList(value)
+	label := ctx.scope.label() + " " + displayName(variable)
+
+	absent := 0
+	for _, arg := range args {
+		entry, err := variableEntry(variable, arg, false, false)
+		if err != nil {
+			return err
+		}
+		if i := indexOfEntry(entries, entry); i >= 0 {
+			ctx.out.add(entryResult{variable, entry, "present", i + 1}, fmt.Sprintf("'%s' is in %s (position %d).", entry, label, i+1))
+			continue
+		}
+		absent++
+		ctx.out.add(entryResult{variable, entry, "absent", 0}, fmt.Sprintf("'%s' is not in %s.", entry, label))
+	}
+	if absent > 0 {
+		return withCode(exitNotFound, fmt.Errorf("%d of %d entries not found in %s", absent, len(args), label))
+	}
+	return nil
+}
+
+func runCleanup(ctx *cliContext, variable string, cleanup func(envStore, envScope, string) ([]string, error)) error {
+	store, err := ctx.openStore()
+	if err != nil {
+		return err
+	}
+	label := ctx.scope.label() + " " + displayName(variable)
+	removed, err := cleanup(store, ctx.scope, variable)
+	if err != nil {
+		return err
+	}
+	for _, e := range removed {
+		ctx.out.add(entryResult{variable, e, "removed", 0}, fmt.Sprintf("Removed '%s' from %s.", e, label))
+	}
+	if len(removed) == 0 && !ctx.out.json {
+		fmt.Printf("Nothing to remove from %s.\n", label)
+	}
+	return nil
+}
+
+func runMove(ctx *cliContext, variable, arg string, top bool) error {
+	store, err := ctx.openStore()
+	if err != nil {
+		return err
+	}
+	entry, err := variableEntry(variable, arg, false, false)
+	if err != nil {
+		return err
+	}
+	changed, err := moveInList(store, ctx.scope, variable, entry, top)
+	if err != nil {
+		return err
+	}
+	label := ctx.scope.label() + " " + displayName(variable)
+	position := "BOTTOM"
+	if top {
+		position = "TOP"
+	}
+	if !changed {
+		ctx.out.add(entryResult{variable, entry, "unchanged", 0}, fmt.Sprintf("'%s' is already at the %s of %s.", entry, position, label))
+		return nil
+	}
+	ctx.out.add(entryResult{variable, entry, "moved", 0}, fmt.Sprintf("'%s' moved to the %s of %s.", entry, position, label))
+	return nil
+}
+
+// Path and PSModulePath in their usual spelling; other names as typed
+func canonicalVariable(name string) string {
+	if canonical, ok := pathVariables[strings.ToLower(name)]; ok {
+		return canonical
+	}
+	return name
+}
+
+// Directory entries are resolved like add/remove do; anything else is taken as typed
+func variableEntry(variable, arg string, fileToParent, mustExist bool) (string, error) {
+	if _, isPath := pathVariables[strings.ToLower(variable)]; isPath {
+		return resolveEntry(arg, fileToParent, mustExist)
+	}
+	entry := strings.TrimSpace(arg)
+	if entry == "" {
+		return "", withCode(exitUsage, errors.New("entry is empty"))
 	}
+	return entry, nil
 }
 
-func getFunctionCall(command string) string {
-	switch command {
-	case "add-topath":
-		return "Add-ToPath"
-	case "remove-frompath":
-		return "Remove-FromPath"
-	case "add-topsmodulepath":
-		return "Add-ToPSModulePath"
-	case "remove-frompsmodulepath":
-		return "Remove-FromPSModulePath"
-	default:
-		return ""
+// PATH is written in capitals in messages, as the PowerShell functions did
+func displayName(variable string) string {
+	if strings.EqualFold(variable, "Path") {
+		return "PATH"
 	}
+	return variable
 }
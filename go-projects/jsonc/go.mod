@@ -0,0 +1,5 @@
+module jsonc
+
+go 1.24.4
+
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,239 @@
+// Package jsonc reads JSON with comments and trailing commas, the format of
+// VS Code's and Windows Terminal's settings files, and updates it with small
+// text edits so comments, key order and formatting survive. It also has what
+// the tools that edit those files share: loading the YAML that describes the
+// wanted settings, and merging it into what the file already says.
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// A value in the text and where it sits
+type node struct {
+	kind  byte // '{', '[', or 0 for strings, numbers, true, false and null
+	start int  // first byte of the value
+	end   int  // just past the value
+	items []*item
+}
+
+// A member of an object or an element of an array
+type item struct {
+	key   string // for members
+	start int    // the key of a member, the value of an element
+	value *node
+	comma int // the comma after it, or -1
+}
+
+type parser struct {
+	data []byte
+	pos  int
+}
+
+// parse reads data into a tree of nodes; nil if there is nothing but
+// whitespace and comments
+func parse(data []byte) (*node, error) {
+	p := &parser{data: data}
+	if bytes.HasPrefix(data, []byte("\xef\xbb\xbf")) {
+		p.pos = 3 // UTF-8 byte order mark
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos == len(p.data) {
+		return nil, nil
+	}
+	n, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.skip(); err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.data) {
+		return nil, p.errorf("unexpected %q after the value", p.data[p.pos])
+	}
+	return n, nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	line := bytes.Count(p.data[:p.pos], []byte("\n")) + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+// skip passes whitespace and comments
+func (p *parser) skip() error {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			p.pos++
+		case bytes.HasPrefix(p.data[p.pos:], []byte("//")):
+			end := bytes.IndexByte(p.data[p.pos:], '\n')
+			if end < 0 {
+				p.pos = len(p.data)
+			} else {
+				p.pos += end
+			}
+		case bytes.HasPrefix(p.data[p.pos:], []byte("/*")):
+			end := bytes.Index(p.data[p.pos+2:], []byte("*/"))
+			if end < 0 {
+				return p.errorf("unterminated comment")
+			}
+			p.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (p *parser) value() (*node, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.errorf("unexpected end of input")
+	}
+	n := &node{start: p.pos}
+	switch c := p.data[p.pos]; c {
+	case '{', '[':
+		n.kind = c
+		if err := p.container(n); err != nil {
+			return nil, err
+		}
+	case '"':
+		if err := p.str(); err != nil {
+			return nil, err
+		}
+	default:
+		for p.pos < len(p.data) && bytes.IndexByte([]byte("+-.0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"), p.data[p.pos]) >= 0 {
+			p.pos++
+		}
+		if p.pos == n.start {
+			return nil, p.errorf("unexpected %q", c)
+		}
+	}
+	n.end = p.pos
+	if n.kind == 0 {
+		var v interface{}
+		if err := json.Unmarshal(p.data[n.start:n.end], &v); err != nil {
+			p.pos = n.start
+			return nil, p.errorf("invalid value %s", p.data[n.start:n.end])
+		}
+	}
+	return n, nil
+}
+
+// str passes a string, escapes included
+func (p *parser) str() error {
+	start := p.pos
+	for p.pos++; p.pos < len(p.data); p.pos++ {
+		switch p.data[p.pos] {
+		case '\\':
+			p.pos++
+		case '"':
+			p.pos++
+			return nil
+		case '\n':
+			p.pos = start
+			return p.errorf("unterminated string")
+		}
+	}
+	p.pos = start
+	return p.errorf("unterminated string")
+}
+
+func (p *parser) container(n *node) error {
+	closer := byte('}')
+	if n.kind == '[' {
+		closer = ']'
+	}
+	p.pos++
+	for {
+		if err := p.skip(); err != nil {
+			return err
+		}
+		if p.pos >= len(p.data) {
+			return p.errorf("missing %q", closer)
+		}
+		if p.data[p.pos] == closer {
+			p.pos++
+			return nil
+		}
+		if len(n.items) > 0 && n.items[len(n.items)-1].comma < 0 {
+			return p.errorf("expected ',' or %q", closer)
+		}
+
+		it := &item{start: p.pos, comma: -1}
+		if n.kind == '{' {
+			if p.data[p.pos] != '"' {
+				return p.errorf("expected a key in quotes")
+			}
+			if err := p.str(); err != nil {
+				return err
+			}
+			if err := json.Unmarshal(p.data[it.start:p.pos], &it.key); err != nil {
+				return p.errorf("invalid key %s", p.data[it.start:p.pos])
+			}
+			if err := p.skip(); err != nil {
+				return err
+			}
+			if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+				return p.errorf("expected ':' after %q", it.key)
+			}
+			p.pos++
+			if err := p.skip(); err != nil {
+				return err
+			}
+		}
+		value, err := p.value()
+		if err != nil {
+			return err
+		}
+		it.value = value
+		n.items = append(n.items, it)
+
+		if err := p.skip(); err != nil {
+			return err
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			it.comma = p.pos
+			p.pos++
+		}
+	}
+}
+
+// decode returns the value as encoding/json would decode it
+func (n *node) decode(data []byte) interface{} {
+	switch n.kind {
+	case '{':
+		obj := make(map[string]interface{}, len(n.items))
+		for _, it := range n.items {
+			obj[it.key] = it.value.decode(data)
+		}
+		return obj
+	case '[':
+		list := make([]interface{}, len(n.items))
+		for i, it := range n.items {
+			list[i] = it.value.decode(data)
+		}
+		return list
+	}
+	var v interface{}
+	json.Unmarshal(data[n.start:n.end], &v)
+	return v
+}
+
+// Unmarshal decodes JSON with comments and trailing commas into v, the way
+// json.Unmarshal does for plain JSON. Input with no value at all (empty, or
+// only comments) leaves v as it is.
+func Unmarshal(data []byte, v interface{}) error {
+	root, err := parse(data)
+	if err != nil || root == nil {
+		return err
+	}
+	plain, err := json.Marshal(root.decode(data))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plain, v)
+}
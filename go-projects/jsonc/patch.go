@@ -0,0 +1,514 @@
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// One text change: data[start:end] becomes text
+type edit struct {
+	start, end int
+	text       string
+}
+
+type patcher struct {
+	data     []byte
+	src      []byte // v encoded, which new values are rendered from
+	unit     string // one level of indentation
+	newline  string
+	removals []edit
+	changes  []edit
+}
+
+// Patch returns data changed so that it decodes to v, the way json.Marshal
+// would encode v. Only what differs is rewritten: comments, key order,
+// formatting and values that stay the same are left as they are. New keys go
+// at the end of their object, in the order v has them (struct fields in
+// declaration order, map keys sorted).
+func Patch(data []byte, v interface{}) ([]byte, error) {
+	src, err := encode(v)
+	if err != nil {
+		return nil, err
+	}
+	srcRoot, err := parse(src)
+	if err != nil {
+		return nil, err
+	}
+	want := srcRoot.decode(src)
+
+	// Removals go first, each pass on the text the last one left, so that
+	// they never overlap the edits that add or change values
+	for pass := 0; pass < 4; pass++ {
+		root, err := parse(data)
+		if err != nil {
+			return nil, err
+		}
+		p := &patcher{data: data, src: src, unit: indentUnit(data), newline: "\n"}
+		if bytes.Contains(data, []byte("\r\n")) {
+			p.newline = "\r\n"
+		}
+		if root == nil {
+			// Nothing but comments, if that: keep them and add the value
+			out := bytes.TrimRight(data, " \t\r\n")
+			if len(out) > 0 {
+				out = append(out, p.newline...)
+			}
+			return append(out, p.render(srcRoot, "")+p.newline...), nil
+		}
+
+		p.diff(root, want, srcRoot)
+		if len(p.removals) > 0 {
+			if data, err = apply(data, p.removals); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if data, err = apply(data, p.changes); err != nil {
+			return nil, err
+		}
+
+		root, err = parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("jsonc: patched text does not parse: %v", err)
+		}
+		if !reflect.DeepEqual(root.decode(data), want) {
+			return nil, errors.New("jsonc: patched text does not hold the wanted value")
+		}
+		return data, nil
+	}
+	return nil, errors.New("jsonc: removals did not settle")
+}
+
+// encode is json.Marshal without the escaping of <, > and & meant for HTML
+func encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// apply makes the edits, which must not overlap. Of two edits starting at
+// the same place, the insertion ends up first.
+func apply(data []byte, edits []edit) ([]byte, error) {
+	sort.SliceStable(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start > edits[j].start
+		}
+		return edits[i].end > edits[j].end
+	})
+	out := append([]byte(nil), data...)
+	limit := len(data)
+	for _, e := range edits {
+		if e.end > limit {
+			return nil, errors.New("jsonc: overlapping edits")
+		}
+		out = append(out[:e.start:e.start], append([]byte(e.text), out[e.end:]...)...)
+		limit = e.start
+	}
+	return out, nil
+}
+
+// The first indentation in the text, or four spaces
+func indentUnit(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && trimmed != "\r" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "    "
+}
+
+// The indentation of the line pos is on
+func (p *patcher) indentAt(pos int) string {
+	start := bytes.LastIndexByte(p.data[:pos], '\n') + 1
+	end := start
+	for end < len(p.data) && (p.data[end] == ' ' || p.data[end] == '\t') {
+		end++
+	}
+	return string(p.data[start:end])
+}
+
+// The start of the line pos is on, if only indentation comes before pos; -1
+// otherwise
+func (p *patcher) lineStart(pos int) int {
+	start := bytes.LastIndexByte(p.data[:pos], '\n') + 1
+	if strings.TrimLeft(string(p.data[start:pos]), " \t") != "" {
+		return -1
+	}
+	return start
+}
+
+// Where the line pos is on ends, if only whitespace and comments follow pos
+// on it: the position of the newline, and the position after it
+func (p *patcher) lineEnd(pos int) (end, next int, ok bool) {
+	for pos < len(p.data) {
+		switch rest := p.data[pos:]; {
+		case rest[0] == ' ' || rest[0] == '\t':
+			pos++
+		case bytes.HasPrefix(rest, []byte("//")):
+			if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+				pos += i
+			} else {
+				pos = len(p.data)
+			}
+		case bytes.HasPrefix(rest, []byte("/*")):
+			i := bytes.Index(rest, []byte("*/"))
+			if i < 0 || bytes.IndexByte(rest[:i], '\n') >= 0 {
+				return pos, pos, false
+			}
+			pos += i + 2
+		case bytes.HasPrefix(rest, []byte("\r\n")):
+			return pos, pos + 2, true
+		case rest[0] == '\n':
+			return pos, pos + 1, true
+		default:
+			return pos, pos, false
+		}
+	}
+	return pos, pos, true
+}
+
+// render lays out s, a value of the encoded v, for a place whose line is
+// indented by indent
+func (p *patcher) render(s *node, indent string) string {
+	var buf bytes.Buffer
+	json.Indent(&buf, p.src[s.start:s.end], indent, p.unit)
+	return strings.ReplaceAll(buf.String(), "\n", p.newline)
+}
+
+// renderInline puts compact JSON on one line, spaced the way people write it
+func renderInline(compact []byte) string {
+	var out strings.Builder
+	inString := false
+	for i, c := range compact {
+		out.WriteByte(c)
+		switch {
+		case inString:
+			if c == '"' && !escaped(compact, i) {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == ':' || c == ',':
+			out.WriteByte(' ')
+		}
+	}
+	return out.String()
+}
+
+// Whether the byte at i is escaped by the backslashes before it
+func escaped(data []byte, i int) bool {
+	n := 0
+	for i > 0 && data[i-1] == '\\' {
+		n++
+		i--
+	}
+	return n%2 == 1
+}
+
+// A value that was on one line stays on one line if it still fits
+func (p *patcher) replace(n *node, s *node) {
+	text := renderInline(p.src[s.start:s.end])
+	if bytes.IndexByte(p.data[n.start:n.end], '\n') >= 0 || len(text) > 80 {
+		text = p.render(s, p.indentAt(n.start))
+	}
+	p.changes = append(p.changes, edit{n.start, n.end, text})
+}
+
+// diff records the edits that make n say want, which s encodes
+func (p *patcher) diff(n *node, want interface{}, s *node) {
+	switch w := want.(type) {
+	case map[string]interface{}:
+		if n.kind != '{' {
+			p.replace(n, s)
+			return
+		}
+		p.diffObject(n, w, s)
+	case []interface{}:
+		if n.kind != '[' {
+			p.replace(n, s)
+			return
+		}
+		p.diffArray(n, w, s)
+	default:
+		if n.kind != 0 || !reflect.DeepEqual(n.decode(p.data), w) {
+			p.replace(n, s)
+		}
+	}
+}
+
+func (p *patcher) diffObject(n *node, want map[string]interface{}, s *node) {
+	members := make(map[string]*node, len(s.items))
+	for _, it := range s.items {
+		members[it.key] = it.value
+	}
+
+	// The last of repeated keys is the one that counts; the others go
+	last := make(map[string]int, len(n.items))
+	for i, it := range n.items {
+		last[it.key] = i
+	}
+	removed := make([]bool, len(n.items))
+	for i, it := range n.items {
+		value, ok := want[it.key]
+		if !ok || last[it.key] != i {
+			removed[i] = true
+			continue
+		}
+		p.diff(it.value, value, members[it.key])
+	}
+	p.remove(n, removed)
+
+	var added []string
+	indent := p.childIndent(n)
+	for _, it := range s.items {
+		if _, ok := last[it.key]; ok {
+			continue
+		}
+		key, _ := encode(it.key)
+		added = append(added, renderInline(key)+": "+p.renderItem(n, it.value, indent))
+	}
+	if len(added) > 0 {
+		p.insert(n, len(n.items)-1, added, indent)
+	}
+}
+
+func (p *patcher) diffArray(n *node, want []interface{}, s *node) {
+	// Line up the elements that are already there unchanged, then pair off
+	// the ones in between: changed elements are patched in place, and the
+	// rest are removed or inserted
+	have := make([]string, len(n.items))
+	for i, it := range n.items {
+		data, _ := json.Marshal(it.value.decode(p.data))
+		have[i] = string(data)
+	}
+	wanted := make([]string, len(want))
+	for j, v := range want {
+		data, _ := json.Marshal(v)
+		wanted[j] = string(data)
+	}
+	pairs := commonSubsequence(have, wanted)
+	pairs = append(pairs, [2]int{len(have), len(wanted)})
+
+	removed := make([]bool, len(n.items))
+	indent := p.childIndent(n)
+	i, j := 0, 0
+	for _, pair := range pairs {
+		for ; i < pair[0] && j < pair[1]; i, j = i+1, j+1 {
+			p.diff(n.items[i].value, want[j], s.items[j].value)
+		}
+		for ; i < pair[0]; i++ {
+			removed[i] = true
+		}
+		if j < pair[1] {
+			elements := make([]string, 0, pair[1]-j)
+			for ; j < pair[1]; j++ {
+				elements = append(elements, p.renderItem(n, s.items[j].value, indent))
+			}
+			p.insert(n, i-1, elements, indent)
+		}
+		i, j = pair[0]+1, pair[1]+1
+	}
+	p.remove(n, removed)
+}
+
+// The longest run of equal strings a and b have in common, in order, as
+// index pairs
+func commonSubsequence(a, b []string) [][2]int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+	var pairs [][2]int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			pairs = append(pairs, [2]int{i, j})
+			i, j = i+1, j+1
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
+
+// Whether the items of n sit on lines of their own
+func (p *patcher) multiline(n *node) bool {
+	if len(n.items) == 0 {
+		return bytes.IndexByte(p.data[n.start:n.end], '\n') >= 0
+	}
+	return p.lineStart(n.items[0].start) >= 0
+}
+
+// A new item of n goes on one line if the others are
+func (p *patcher) renderItem(n *node, s *node, indent string) string {
+	if len(n.items) > 0 && !p.multiline(n) {
+		return renderInline(p.src[s.start:s.end])
+	}
+	return p.render(s, indent)
+}
+
+// The indentation for a new item of n
+func (p *patcher) childIndent(n *node) string {
+	if len(n.items) > 0 && p.lineStart(n.items[0].start) >= 0 {
+		return p.indentAt(n.items[0].start)
+	}
+	return p.indentAt(n.start) + p.unit
+}
+
+// The end of an item, its comma included
+func (it *item) end() int {
+	if it.comma >= 0 {
+		return it.comma + 1
+	}
+	return it.value.end
+}
+
+// remove records the removal of the marked items of n. An item on lines of
+// its own takes those lines with it, trailing comment included.
+func (p *patcher) remove(n *node, removed []bool) {
+	for i := 0; i < len(n.items); i++ {
+		if !removed[i] {
+			continue
+		}
+		j := i
+		for j+1 < len(n.items) && removed[j+1] {
+			j++
+		}
+		first, last := n.items[i], n.items[j]
+
+		switch {
+		case i == 0 && j == len(n.items)-1:
+			// Nothing left
+			p.removals = append(p.removals, edit{n.start + 1, n.end - 1, ""})
+		case j < len(n.items)-1:
+			start, end := first.start, n.items[j+1].start
+			if lineStart := p.lineStart(first.start); lineStart >= 0 {
+				if _, next, ok := p.lineEnd(last.end()); ok {
+					start, end = lineStart, next
+				}
+			}
+			p.removals = append(p.removals, edit{start, end, ""})
+		default:
+			// The items run to the end: the one before loses its comma,
+			// unless the list keeps a trailing comma
+			prev := n.items[i-1]
+			lineStart := p.lineStart(first.start)
+			_, next, ok := p.lineEnd(last.end())
+			if lineStart >= 0 && ok {
+				if last.comma < 0 {
+					p.removals = append(p.removals, edit{prev.comma, prev.comma + 1, ""})
+				}
+				p.removals = append(p.removals, edit{lineStart, next, ""})
+			} else {
+				start := prev.comma
+				if last.comma >= 0 {
+					start = prev.comma + 1
+				}
+				p.removals = append(p.removals, edit{start, last.end(), ""})
+			}
+		}
+		i = j
+	}
+}
+
+// insert records the insertion of texts (rendered items) after the item at
+// index after, or at the start of n if after is -1
+func (p *patcher) insert(n *node, after int, texts []string, indent string) {
+	if len(n.items) == 0 {
+		inner := string(p.data[n.start+1 : n.end-1])
+		if strings.TrimSpace(inner) == "" {
+			text := strings.Join(texts, ", ")
+			if p.multiline(n) || len(texts) > 1 || strings.Contains(text, "\n") {
+				nl := p.newline
+				text = nl + indent + strings.Join(texts, ","+nl+indent) + nl + p.indentAt(n.start)
+			}
+			p.changes = append(p.changes, edit{n.start + 1, n.end - 1, text})
+			return
+		}
+		// Only comments inside: add the items before the closing bracket, on
+		// lines of their own
+		nl := p.newline
+		items := indent + strings.Join(texts, ","+nl+indent)
+		if lineStart := p.lineStart(n.end - 1); lineStart >= 0 {
+			p.changes = append(p.changes, edit{lineStart, lineStart, items + nl})
+			return
+		}
+		at := n.end - 1
+		for at > n.start && (p.data[at-1] == ' ' || p.data[at-1] == '\t') {
+			at--
+		}
+		p.changes = append(p.changes, edit{at, n.end - 1, nl + items + nl + p.indentAt(n.start)})
+		return
+	}
+
+	nl := p.newline
+	if after < 0 {
+		first := n.items[0]
+		if lineStart := p.lineStart(first.start); lineStart >= 0 {
+			var text strings.Builder
+			for _, t := range texts {
+				text.WriteString(indent + t + "," + nl)
+			}
+			p.changes = append(p.changes, edit{lineStart, lineStart, text.String()})
+			return
+		}
+		p.changes = append(p.changes, edit{first.start, first.start, strings.Join(texts, ", ") + ", "})
+		return
+	}
+
+	anchor := n.items[after]
+	trailing := anchor.comma >= 0 // the new items end with a comma too
+	if !p.multiline(n) {
+		if trailing {
+			text := " " + strings.Join(texts, ", ") + ","
+			p.changes = append(p.changes, edit{anchor.comma + 1, anchor.comma + 1, text})
+			return
+		}
+		p.changes = append(p.changes, edit{anchor.value.end, anchor.value.end, ", " + strings.Join(texts, ", ")})
+		return
+	}
+
+	var text strings.Builder
+	for i, t := range texts {
+		text.WriteString(nl + indent + t)
+		if trailing || i < len(texts)-1 {
+			text.WriteString(",")
+		}
+	}
+	at, _, ok := p.lineEnd(anchor.end())
+	if !ok {
+		at = anchor.end()
+	}
+	if trailing {
+		p.changes = append(p.changes, edit{at, at, text.String()})
+		return
+	}
+	if at == anchor.value.end {
+		p.changes = append(p.changes, edit{at, at, "," + text.String()})
+		return
+	}
+	p.changes = append(p.changes,
+		edit{anchor.value.end, anchor.value.end, ","},
+		edit{at, at, text.String()})
+}
@@ -0,0 +1,83 @@
+package jsonc
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// Normalize makes what v points to look like decoded JSON: it is replaced
+// with what its JSON encoding decodes back to, so numbers read from YAML as
+// int become float64 like the ones in settings files
+func Normalize(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	target := reflect.ValueOf(v).Elem()
+	target.Set(reflect.Zero(target.Type()))
+	return json.Unmarshal(data, v)
+}
+
+// Merge lays patch over target and returns the result. Objects are merged
+// key by key and a null in patch removes the key. A list in patch goes
+// through lists, with the key it sits under and the list target had there,
+// when lists is not nil; anything else in patch replaces what target had.
+func Merge(target, patch interface{}, lists func(key string, target, patch []interface{}) []interface{}) interface{} {
+	return merge("", target, patch, lists)
+}
+
+func merge(key string, target, patch interface{}, lists func(key string, target, patch []interface{}) []interface{}) interface{} {
+	switch patch := patch.(type) {
+	case map[string]interface{}:
+		targetObj, ok := target.(map[string]interface{})
+		if !ok {
+			targetObj = make(map[string]interface{})
+		}
+		for k, value := range patch {
+			if value == nil {
+				delete(targetObj, k)
+				continue
+			}
+			targetObj[k] = merge(k, targetObj[k], value, lists)
+		}
+		return targetObj
+	case []interface{}:
+		if lists != nil {
+			targetList, _ := target.([]interface{})
+			return lists(key, targetList, patch)
+		}
+	}
+	return patch
+}
+
+var envVarRe = regexp.MustCompile(`%(\w+)%`)
+
+// ExpandEnv replaces the %VARIABLES% in the strings of v, which may be a
+// string or decoded JSON. Variables that are not set are left as they are.
+func ExpandEnv(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return envVarRe.ReplaceAllStringFunc(v, func(name string) string {
+			if value, ok := os.LookupEnv(strings.Trim(name, "%")); ok {
+				return value
+			}
+			return name
+		})
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[key] = ExpandEnv(value)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = ExpandEnv(value)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,27 @@
+package jsonc
+
+import (
+	"bytes"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadYAML decodes the YAML file at path, or builtin if path is empty, into
+// v, rejecting keys v has no field for. The result is normalized, so the
+// values in it compare equal to the ones Unmarshal reads from settings files.
+func LoadYAML(path string, builtin []byte, v interface{}) error {
+	data := builtin
+	if path != "" {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return err
+		}
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	return Normalize(v)
+}
@@ -121,7 +121,7 @@ if (Test-Path $ps7_profile_exe) {
     Write-Warning "⚠️ PowerShell 7 profile updater not found"
 }
 
-# --- Step 7: Run enable-ssh.exe if SSH is enabled in config.yaml ---
+# --- Step 7: Run enable-ssh.exe to turn SSH on or off as config.yaml says ---
 
 $enable_ssh_exe = Join-Path $base_path "go-projects\enable-ssh\enable-ssh.exe"
 $enable_ssh_log = Join-Path $env:TEMP "$(Get-Date -Format 'yyyy-MM-dd_HH-mm-ss')_enable-ssh.log"